@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Supported values of the auth type
+const (
+	authNone   = "none"
+	authBasic  = "basic"
+	authBearer = "bearer"
+	authAPIKey = "api_key"
+	authOAuth2 = "oauth2"
+)
+
+// tokenExpiryMargin renews OAuth2 tokens slightly before they expire, so a token does not expire in flight.
+const tokenExpiryMargin = 10 * time.Second
+
+// authOptions authenticates the requests. It can be set for the suite and per test;
+// the auth of a test replaces the suite one ("type": "none" sends no credentials).
+type authOptions struct {
+	Type string `json:"type"`
+	// Username and Password are the basic credentials, or the resource owner of the oauth2 password grant
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Token is the static bearer token
+	Token string `json:"token,omitempty"`
+	// Name and Value are the API key, sent as a header or a query parameter depending on In
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+	In    string `json:"in,omitempty"`
+	// TokenUrl, GrantType, ClientId, ClientSecret and Scope fetch the oauth2 access token.
+	// ClientAuth sends the client credentials as basic auth ("header", the default) or in the form ("body").
+	TokenUrl     string `json:"token_url,omitempty"`
+	GrantType    string `json:"grant_type,omitempty"`
+	ClientId     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	ClientAuth   string `json:"client_auth,omitempty"`
+}
+
+// oauthToken is an access token cached for the whole run.
+type oauthToken struct {
+	accessToken  string
+	refreshToken string
+	// expiry is zero when the token endpoint does not return expires_in
+	expiry time.Time
+}
+
+// oauthTokens caches the OAuth2 tokens by their auth options, so they are fetched once for all tests.
+var oauthTokens = map[string]*oauthToken{}
+
+// process substitutes the variables in the string options.
+func (a *authOptions) process() bool {
+	fields := []*string{&a.Username, &a.Password, &a.Token, &a.Name, &a.Value, &a.TokenUrl, &a.ClientId, &a.ClientSecret, &a.Scope}
+	for _, field := range fields {
+		var ok bool
+		if *field, ok = processString(*field); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the options required by the auth type.
+func (a *authOptions) validate() error {
+	switch a.Type {
+	case authNone:
+	case authBasic:
+		if a.Username == "" {
+			return fmt.Errorf("basic auth needs a username")
+		}
+	case authBearer:
+		if a.Token == "" {
+			return fmt.Errorf("bearer auth needs a token")
+		}
+	case authAPIKey:
+		if a.Name == "" {
+			return fmt.Errorf("api_key auth needs a name")
+		}
+		if a.In != "" && a.In != "header" && a.In != "query" {
+			return fmt.Errorf("unknown api_key in '%s' (use header or query)", a.In)
+		}
+	case authOAuth2:
+		if a.TokenUrl == "" || a.ClientId == "" {
+			return fmt.Errorf("oauth2 auth needs a token_url and a client_id")
+		}
+		switch a.GrantType {
+		case "", "client_credentials":
+		case "password":
+			if a.Username == "" {
+				return fmt.Errorf("oauth2 password grant needs a username")
+			}
+		default:
+			return fmt.Errorf("unknown grant_type '%s' (use client_credentials or password)", a.GrantType)
+		}
+		if a.ClientAuth != "" && a.ClientAuth != "header" && a.ClientAuth != "body" {
+			return fmt.Errorf("unknown client_auth '%s' (use header or body)", a.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("unknown auth type '%s' (use none, basic, bearer, api_key or oauth2)", a.Type)
+	}
+	return nil
+}
+
+// apply adds the credentials to the request. Headers set by the test take precedence.
+// OAuth2 tokens are fetched with the transport of the test.
+func (a *authOptions) apply(req *http.Request, transport http.RoundTripper) error {
+	if a == nil {
+		return nil
+	}
+	setHeader := func(name, value string) {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	switch a.Type {
+	case authBasic:
+		if req.Header.Get("Authorization") == "" {
+			req.SetBasicAuth(a.Username, a.Password)
+		}
+	case authBearer:
+		setHeader("Authorization", "Bearer "+a.Token)
+	case authAPIKey:
+		if a.In == "query" {
+			query := req.URL.Query()
+			query.Set(a.Name, a.Value)
+			req.URL.RawQuery = query.Encode()
+		} else {
+			setHeader(a.Name, a.Value)
+		}
+	case authOAuth2:
+		if req.Header.Get("Authorization") != "" {
+			return nil
+		}
+		token, err := a.oauth2Token(transport)
+		if err != nil {
+			return err
+		}
+		setHeader("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// oauth2Token returns the cached access token of the options, or fetches one.
+// An expired token is renewed with its refresh token when the server issued one, otherwise with the grant again.
+func (a *authOptions) oauth2Token(transport http.RoundTripper) (string, error) {
+	key, _ := json.Marshal(a)
+	cached, ok := oauthTokens[string(key)]
+	if ok && (cached.expiry.IsZero() || time.Now().Before(cached.expiry)) {
+		LogMsg("[NOTE] Using the cached OAuth2 token.\n")
+		return cached.accessToken, nil
+	}
+
+	if ok && cached.refreshToken != "" {
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {cached.refreshToken}}
+		token, err := a.requestToken(form, transport)
+		if err == nil {
+			LogMsg("[NOTE] Refreshed the OAuth2 token.\n")
+			if token.refreshToken == "" {
+				// The server may keep the refresh token unchanged without returning it again
+				token.refreshToken = cached.refreshToken
+			}
+			oauthTokens[string(key)] = token
+			return token.accessToken, nil
+		}
+		LogMsg("[NOTE] Could not refresh the OAuth2 token, requesting a new one: %v\n", err)
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if a.GrantType == "password" {
+		form.Set("grant_type", "password")
+		form.Set("username", a.Username)
+		form.Set("password", a.Password)
+	}
+	if a.Scope != "" {
+		form.Set("scope", a.Scope)
+	}
+	token, err := a.requestToken(form, transport)
+	if err != nil {
+		return "", err
+	}
+	LogMsg("[NOTE] Fetched an OAuth2 token from %s.\n", a.TokenUrl)
+	oauthTokens[string(key)] = token
+	return token.accessToken, nil
+}
+
+// requestToken posts a token request to the token endpoint.
+func (a *authOptions) requestToken(form url.Values, transport http.RoundTripper) (*oauthToken, error) {
+	if a.ClientAuth == "body" {
+		form.Set("client_id", a.ClientId)
+		form.Set("client_secret", a.ClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, a.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.ClientAuth != "body" {
+		// RFC 6749 2.3.1: the client credentials are form-encoded before basic auth
+		req.SetBasicAuth(url.QueryEscape(a.ClientId), url.QueryEscape(a.ClientSecret))
+	}
+
+	client := http.Client{Transport: transport, Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %v", err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("token response: %v", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", res.Status, strings.TrimSpace(string(data)))
+	}
+
+	var body struct {
+		AccessToken  string      `json:"access_token"`
+		RefreshToken string      `json:"refresh_token"`
+		ExpiresIn    json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("token response: %v", err)
+	}
+	if body.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+
+	token := &oauthToken{accessToken: body.AccessToken, refreshToken: body.RefreshToken}
+	if seconds, err := body.ExpiresIn.Float64(); err == nil && seconds > 0 {
+		token.expiry = time.Now().Add(time.Duration(seconds*float64(time.Second)) - tokenExpiryMargin)
+	}
+	return token, nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenRequest is a request received by the token endpoint stub.
+type tokenRequest struct {
+	form                 url.Values
+	basicUser, basicPass string
+	hasBasic             bool
+}
+
+// tokenEndpoint is an OAuth2 token endpoint stub. It issues "access-N" tokens with the
+// configured expires_in and refresh token, and records every request.
+type tokenEndpoint struct {
+	*httptest.Server
+	mu           sync.Mutex
+	requests     []tokenRequest
+	expiresIn    int
+	refreshToken string
+	// failRefresh rejects refresh_token grants, as a server that revoked the token
+	failRefresh bool
+}
+
+func newTokenEndpoint(tb testing.TB) *tokenEndpoint {
+	tb.Helper()
+	e := &tokenEndpoint{}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		req := tokenRequest{form: r.PostForm}
+		req.basicUser, req.basicPass, req.hasBasic = r.BasicAuth()
+
+		e.mu.Lock()
+		e.requests = append(e.requests, req)
+		n := len(e.requests)
+		e.mu.Unlock()
+
+		if r.PostForm.Get("grant_type") == "refresh_token" && e.failRefresh {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		body := map[string]any{"access_token": fmt.Sprintf("access-%d", n), "token_type": "Bearer"}
+		if e.expiresIn > 0 {
+			body["expires_in"] = e.expiresIn
+		}
+		if e.refreshToken != "" {
+			body["refresh_token"] = e.refreshToken
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+	tb.Cleanup(e.Close)
+	// Tokens are cached for the whole run, each test starts without any
+	oauthTokens = map[string]*oauthToken{}
+	return e
+}
+
+// authorize applies the auth options to a new request and returns its Authorization header.
+func authorize(tb testing.TB, a *authOptions) string {
+	tb.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "http://api.local/me", nil)
+	if err := a.apply(req, http.DefaultTransport); err != nil {
+		tb.Fatalf("apply: %v", err)
+	}
+	return req.Header.Get("Authorization")
+}
+
+func TestOAuth2Grants(t *testing.T) {
+	tests := []struct {
+		name string
+		auth authOptions
+		// form is the expected token request form, basic the expected client credentials header
+		form  url.Values
+		basic []string
+	}{
+		{
+			name:  "client credentials, header",
+			auth:  authOptions{Type: authOAuth2, ClientId: "app", ClientSecret: "s3cret", Scope: "read write"},
+			form:  url.Values{"grant_type": {"client_credentials"}, "scope": {"read write"}},
+			basic: []string{"app", "s3cret"},
+		},
+		{
+			name: "client credentials, body",
+			auth: authOptions{Type: authOAuth2, GrantType: "client_credentials", ClientId: "app", ClientSecret: "s3cret", ClientAuth: "body"},
+			form: url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {"s3cret"}},
+		},
+		{
+			name:  "password, header",
+			auth:  authOptions{Type: authOAuth2, GrantType: "password", ClientId: "app", ClientSecret: "s3cret", Username: "ada", Password: "pw"},
+			form:  url.Values{"grant_type": {"password"}, "username": {"ada"}, "password": {"pw"}},
+			basic: []string{"app", "s3cret"},
+		},
+		{
+			name: "password, body",
+			auth: authOptions{Type: authOAuth2, GrantType: "password", ClientId: "app", ClientSecret: "s3cret", Username: "ada", Password: "pw", ClientAuth: "body"},
+			form: url.Values{"grant_type": {"password"}, "username": {"ada"}, "password": {"pw"}, "client_id": {"app"}, "client_secret": {"s3cret"}},
+		},
+		{
+			// RFC 6749 2.3.1: the client credentials are form-encoded before basic auth
+			name:  "client credentials with special characters",
+			auth:  authOptions{Type: authOAuth2, ClientId: "my app", ClientSecret: "a:b&c"},
+			form:  url.Values{"grant_type": {"client_credentials"}},
+			basic: []string{"my+app", "a%3Ab%26c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := newTokenEndpoint(t)
+			tt.auth.TokenUrl = endpoint.URL
+			if err := tt.auth.validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+
+			if got := authorize(t, &tt.auth); got != "Bearer access-1" {
+				t.Errorf("Authorization: got %q, want Bearer access-1", got)
+			}
+			if len(endpoint.requests) != 1 {
+				t.Fatalf("got %d token requests, want 1", len(endpoint.requests))
+			}
+			req := endpoint.requests[0]
+			if req.form.Encode() != tt.form.Encode() {
+				t.Errorf("form: got %s, want %s", req.form.Encode(), tt.form.Encode())
+			}
+			if tt.basic == nil {
+				if req.hasBasic {
+					t.Errorf("client credentials sent as basic auth too (%s)", req.basicUser)
+				}
+			} else if !req.hasBasic || req.basicUser != tt.basic[0] || req.basicPass != tt.basic[1] {
+				t.Errorf("basic auth: got %q:%q (%v), want %q:%q", req.basicUser, req.basicPass, req.hasBasic, tt.basic[0], tt.basic[1])
+			}
+		})
+	}
+}
+
+func TestOAuth2TokenCache(t *testing.T) {
+	endpoint := newTokenEndpoint(t)
+	endpoint.expiresIn = 3600
+	suite := authOptions{Type: authOAuth2, TokenUrl: endpoint.URL, ClientId: "app", ClientSecret: "s3cret"}
+
+	// Each test gets its own copy of the suite auth, the token is shared
+	for i := range 3 {
+		auth := suite
+		if got := authorize(t, &auth); got != "Bearer access-1" {
+			t.Errorf("test %d: got %q, want the cached token", i+1, got)
+		}
+	}
+	if len(endpoint.requests) != 1 {
+		t.Errorf("got %d token requests, want 1", len(endpoint.requests))
+	}
+
+	// Other credentials have their own token
+	other := suite
+	other.Scope = "admin"
+	if got := authorize(t, &other); got != "Bearer access-2" {
+		t.Errorf("other scope: got %q, want a new token", got)
+	}
+
+	// A token without expires_in is kept for the whole run
+	endpoint.expiresIn = 0
+	forever := suite
+	forever.ClientId = "forever"
+	authorize(t, &forever)
+	if got := authorize(t, &forever); got != "Bearer access-3" {
+		t.Errorf("token without expiry: got %q, want the cached token", got)
+	}
+
+	// An Authorization header set by the test is kept and no token is fetched
+	req, _ := http.NewRequest(http.MethodGet, "http://api.local/me", nil)
+	req.Header.Set("Authorization", "Bearer mine")
+	auth := suite
+	auth.ClientId = "unused"
+	if err := auth.apply(req, http.DefaultTransport); err != nil || req.Header.Get("Authorization") != "Bearer mine" {
+		t.Errorf("explicit header: got %q, %v", req.Header.Get("Authorization"), err)
+	}
+	if len(endpoint.requests) != 3 {
+		t.Errorf("got %d token requests, want 3", len(endpoint.requests))
+	}
+}
+
+// expireToken makes the cached token of the options expire, as if expires_in had passed.
+func expireToken(tb testing.TB, a *authOptions) {
+	tb.Helper()
+	key, _ := json.Marshal(a)
+	token, ok := oauthTokens[string(key)]
+	if !ok {
+		tb.Fatal("no cached token")
+	}
+	token.expiry = time.Now().Add(-time.Second)
+}
+
+func TestOAuth2Refresh(t *testing.T) {
+	endpoint := newTokenEndpoint(t)
+	endpoint.expiresIn = 3600
+	endpoint.refreshToken = "refresh-1"
+	auth := authOptions{Type: authOAuth2, TokenUrl: endpoint.URL, ClientId: "app", ClientSecret: "s3cret", ClientAuth: "body"}
+
+	authorize(t, &auth)
+	expireToken(t, &auth)
+	if got := authorize(t, &auth); got != "Bearer access-2" {
+		t.Fatalf("after expiry: got %q, want the refreshed token", got)
+	}
+	refresh := endpoint.requests[1].form
+	want := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"refresh-1"}, "client_id": {"app"}, "client_secret": {"s3cret"}}
+	if refresh.Encode() != want.Encode() {
+		t.Errorf("refresh form: got %s, want %s", refresh.Encode(), want.Encode())
+	}
+
+	// The refresh token is kept when the server does not return a new one
+	endpoint.refreshToken = ""
+	expireToken(t, &auth)
+	authorize(t, &auth)
+	if got := endpoint.requests[2].form.Get("refresh_token"); got != "refresh-1" {
+		t.Errorf("second refresh: got refresh_token %q, want refresh-1", got)
+	}
+
+	// A rejected refresh falls back to the grant
+	endpoint.failRefresh = true
+	expireToken(t, &auth)
+	if got := authorize(t, &auth); got != "Bearer access-5" {
+		t.Errorf("after a failed refresh: got %q, want a new token", got)
+	}
+	if grant := endpoint.requests[4].form.Get("grant_type"); grant != "client_credentials" {
+		t.Errorf("after a failed refresh: got grant_type %q, want client_credentials", grant)
+	}
+}
+
+func TestOAuth2Expiry(t *testing.T) {
+	endpoint := newTokenEndpoint(t)
+	auth := authOptions{Type: authOAuth2, TokenUrl: endpoint.URL, ClientId: "app"}
+
+	// Without a refresh token an expired token is requested again. A token expiring within
+	// the margin is already renewed.
+	endpoint.expiresIn = int(tokenExpiryMargin/time.Second) - 1
+	authorize(t, &auth)
+	if got := authorize(t, &auth); got != "Bearer access-2" {
+		t.Errorf("token within the expiry margin: got %q, want a new token", got)
+	}
+	if grant := endpoint.requests[1].form.Get("grant_type"); grant != "client_credentials" {
+		t.Errorf("renewal: got grant_type %q, want client_credentials", grant)
+	}
+}
+
+func TestOAuth2Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/denied":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+		case "/empty":
+			fmt.Fprint(w, `{"token_type":"Bearer"}`)
+		default:
+			fmt.Fprint(w, `<html>`)
+		}
+	}))
+	defer server.Close()
+	oauthTokens = map[string]*oauthToken{}
+
+	tests := []struct {
+		path, err string
+	}{
+		{"/denied", `token endpoint returned 401 Unauthorized: {"error":"invalid_client"}`},
+		{"/empty", "token response has no access_token"},
+		{"/html", "token response: "},
+	}
+	for _, tt := range tests {
+		auth := authOptions{Type: authOAuth2, TokenUrl: server.URL + tt.path, ClientId: "app"}
+		req, _ := http.NewRequest(http.MethodGet, "http://api.local/me", nil)
+		if err := auth.apply(req, http.DefaultTransport); err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.path, err, tt.err)
+		}
+	}
+}
+
+func TestAuthApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   authOptions
+		header string
+		want   string
+		url    string
+	}{
+		{"basic", authOptions{Type: authBasic, Username: "ada", Password: "pw"}, "Authorization", "Basic YWRhOnB3", ""},
+		{"bearer", authOptions{Type: authBearer, Token: "abc"}, "Authorization", "Bearer abc", ""},
+		{"api key header", authOptions{Type: authAPIKey, Name: "X-Api-Key", Value: "k1"}, "X-Api-Key", "k1", ""},
+		{"api key query", authOptions{Type: authAPIKey, Name: "api_key", Value: "k 1", In: "query"}, "", "", "http://api.local/me?api_key=k+1&page=2"},
+		{"none", authOptions{Type: authNone}, "Authorization", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.auth.validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			req, _ := http.NewRequest(http.MethodGet, "http://api.local/me?page=2", nil)
+			if err := tt.auth.apply(req, nil); err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" && req.Header.Get(tt.header) != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.header, req.Header.Get(tt.header), tt.want)
+			}
+			if tt.url != "" && req.URL.String() != tt.url {
+				t.Errorf("url: got %s, want %s", req.URL, tt.url)
+			}
+		})
+	}
+}
+
+func TestAuthValidate(t *testing.T) {
+	tests := []struct {
+		auth authOptions
+		err  string
+	}{
+		{authOptions{Type: "digest"}, "unknown auth type 'digest'"},
+		{authOptions{Type: authBasic}, "basic auth needs a username"},
+		{authOptions{Type: authBearer}, "bearer auth needs a token"},
+		{authOptions{Type: authAPIKey, Name: "k", In: "cookie"}, "unknown api_key in 'cookie'"},
+		{authOptions{Type: authOAuth2, ClientId: "app"}, "oauth2 auth needs a token_url and a client_id"},
+		{authOptions{Type: authOAuth2, TokenUrl: "http://t", ClientId: "app", GrantType: "implicit"}, "unknown grant_type 'implicit'"},
+		{authOptions{Type: authOAuth2, TokenUrl: "http://t", ClientId: "app", GrantType: "password"}, "oauth2 password grant needs a username"},
+		{authOptions{Type: authOAuth2, TokenUrl: "http://t", ClientId: "app", ClientAuth: "jwt"}, "unknown client_auth 'jwt'"},
+	}
+	for _, tt := range tests {
+		if err := tt.auth.validate(); err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%+v: got %v, want %q", tt.auth, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Supported values of body_type
+const (
+	bodyJSON      = "json"
+	bodyForm      = "form"
+	bodyMultipart = "multipart"
+	bodyRaw       = "raw"
+)
+
+// buildBody encodes the request body according to body_type and returns it with its Content-Type.
+// body_file and body_raw are sent as-is instead of body, graphql is sent as a GraphQL JSON request.
+// dir is the directory of the suite file, used to resolve body_file and the files of multipart parts.
+// It returns a nil reader when the test has no body.
+func (t *test) buildBody(dir string) (io.Reader, string, error) {
+	set := 0
+	for _, isSet := range []bool{t.Body != nil, t.BodyRaw != "", t.BodyFile != "", t.GraphQL != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, "", fmt.Errorf("only one of body, body_raw, body_file and graphql can be set")
+	}
+
+	switch {
+	case t.GraphQL != nil:
+		data, err := t.GraphQL.body()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid graphql variables: %v", err)
+		}
+		return bytes.NewReader(data), "application/json", nil
+	case t.BodyFile != "":
+		// Streamed from disk, without substitution (binary payloads stay intact)
+		file := t.BodyFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("body_file: %v", err)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(file))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return f, contentType, nil
+	case t.BodyRaw != "":
+		return strings.NewReader(t.BodyRaw), "text/plain; charset=utf-8", nil
+	case t.Body == nil:
+		return nil, "", nil
+	}
+
+	switch t.BodyType {
+	case "", bodyJSON:
+		jsonData, err := json.Marshal(t.Body)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid JSON body: %v", err)
+		}
+		return bytes.NewBuffer(jsonData), "application/json", nil
+
+	case bodyForm:
+		fields, ok := t.Body.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("body_type form needs an object body, got %s", jsonType(t.Body))
+		}
+		form := url.Values{}
+		for name, value := range fields {
+			for _, v := range formValues(value) {
+				form.Add(name, v)
+			}
+		}
+		return strings.NewReader(form.Encode()), "application/x-www-form-urlencoded", nil
+
+	case bodyMultipart:
+		fields, ok := t.Body.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("body_type multipart needs an object body, got %s", jsonType(t.Body))
+		}
+		return buildMultipart(fields, dir)
+
+	case bodyRaw:
+		s, ok := t.Body.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("body_type raw needs a string body, got %s", jsonType(t.Body))
+		}
+		return strings.NewReader(s), "text/plain; charset=utf-8", nil
+	}
+	return nil, "", fmt.Errorf("unknown body_type '%s' (use json, form, multipart or raw)", t.BodyType)
+}
+
+// bodyLength returns the length of a body_file, so it is not sent chunked. It returns -1 for other bodies,
+// whose length http.NewRequest already knows.
+func bodyLength(body io.Reader) int64 {
+	if f, ok := body.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			return info.Size()
+		}
+	}
+	return -1
+}
+
+// formValues converts a form field to its values. Arrays repeat the field (e.g. tags=a&tags=b).
+func formValues(value any) []string {
+	if items, ok := value.([]any); ok {
+		values := make([]string, len(items))
+		for i, item := range items {
+			values[i] = scalarString(item)
+		}
+		return values
+	}
+	return []string{scalarString(value)}
+}
+
+// buildMultipart writes a multipart/form-data body. A field is a file part when its value is an object:
+//
+//	"avatar": {"file": "files/me.png", "filename": "me.png", "content_type": "image/png"}
+//
+// Only "file" is required, the file name defaults to the base name of the path and the content type
+// is guessed from the extension. Other values are sent as plain fields.
+func buildMultipart(fields map[string]any, dir string) (io.Reader, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	// Sorted so the body is the same from one run to the next
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		value := fields[name]
+		part, isFile := value.(map[string]any)
+		if !isFile {
+			for _, v := range formValues(value) {
+				if err := w.WriteField(name, v); err != nil {
+					return nil, "", err
+				}
+			}
+			continue
+		}
+
+		file, _ := part["file"].(string)
+		if file == "" {
+			return nil, "", fmt.Errorf("multipart field '%s': a file part needs a \"file\" path", name)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("multipart field '%s': %v", name, err)
+		}
+
+		filename, _ := part["filename"].(string)
+		if filename == "" {
+			filename = filepath.Base(file)
+		}
+		contentType, _ := part["content_type"].(string)
+		if contentType == "" {
+			if contentType = mime.TypeByExtension(filepath.Ext(file)); contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(name), escapeQuotes(filename)))
+		header.Set("Content-Type", contentType)
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := pw.Write(data); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, w.FormDataContentType(), nil
+}
+
+// escapeQuotes escapes a value for a quoted Content-Disposition parameter.
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// receivedRequest is a request as seen by the server, with its body read.
+type receivedRequest struct {
+	header        http.Header
+	contentLength int64
+	chunked       bool
+	body          []byte
+}
+
+// sendBody sends the body of tc to a server the way the runner does, and returns what the server received.
+func sendBody(tb testing.TB, tc *test, dir string) receivedRequest {
+	tb.Helper()
+	var got receivedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.header = r.Header.Clone()
+		got.contentLength = r.ContentLength
+		got.chunked = len(r.TransferEncoding) > 0
+		got.body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	body, contentType, err := tc.buildBody(dir)
+	if err != nil {
+		tb.Fatalf("buildBody: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, server.URL, body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if length := bodyLength(body); length >= 0 {
+		req.ContentLength = length
+	}
+	for k, v := range tc.Header {
+		req.Header.Set(k, v)
+	}
+	if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	res.Body.Close()
+	return got
+}
+
+func TestBuildBodyJSON(t *testing.T) {
+	got := sendBody(t, &test{Body: mustDecode(t, `{"id": 12345678901234567890, "name": "Ada"}`)}, "")
+	if ct := got.header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: got %s", ct)
+	}
+	if string(got.body) != `{"id":12345678901234567890,"name":"Ada"}` {
+		t.Errorf("body: got %s", got.body)
+	}
+
+	got = sendBody(t, &test{}, "")
+	if len(got.body) != 0 || got.header.Get("Content-Type") != "" {
+		t.Errorf("no body: got %q with Content-Type %q", got.body, got.header.Get("Content-Type"))
+	}
+}
+
+func TestBuildBodyForm(t *testing.T) {
+	tc := &test{BodyType: bodyForm, Body: mustDecode(t, `{"user": "ada lovelace", "tags": ["a", "b&c"], "age": 36, "admin": false}`)}
+	got := sendBody(t, tc, "")
+	if ct := got.header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+		t.Errorf("Content-Type: got %s", ct)
+	}
+	if want := "admin=false&age=36&tags=a&tags=b%26c&user=ada+lovelace"; string(got.body) != want {
+		t.Errorf("body: got %s, want %s", got.body, want)
+	}
+}
+
+func TestBuildBodyMultipart(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "avatar.png"), []byte("\x89PNG\r\n\x1a\n\x00\xff"), 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.unknownext"), []byte("notes"), 0o644)
+
+	tc := &test{BodyType: bodyMultipart, Body: mustDecode(t, `{
+		"name": "Ada",
+		"tags": ["a", "b"],
+		"avatar": {"file": "avatar.png"},
+		"doc": {"file": "notes.unknownext", "filename": "my \"notes\".txt", "content_type": "text/plain"},
+		"raw": {"file": "notes.unknownext"}
+	}`)}
+	got := sendBody(t, tc, dir)
+
+	mediaType, params, err := mime.ParseMediaType(got.header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("Content-Type: got %s (%v)", got.header.Get("Content-Type"), err)
+	}
+	type part struct{ name, filename, contentType, data string }
+	var parts []part
+	r := multipart.NewReader(strings.NewReader(string(got.body)), params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(p)
+		parts = append(parts, part{p.FormName(), p.FileName(), p.Header.Get("Content-Type"), string(data)})
+	}
+	// Fields are sorted by name, files are read relative to dir
+	want := []part{
+		{"avatar", "avatar.png", "image/png", "\x89PNG\r\n\x1a\n\x00\xff"},
+		{"doc", `my "notes".txt`, "text/plain", "notes"},
+		{"name", "", "", "Ada"},
+		{"raw", "notes.unknownext", "application/octet-stream", "notes"},
+		{"tags", "", "", "a"},
+		{"tags", "", "", "b"},
+	}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("got parts\n%q\nwant\n%q", parts, want)
+	}
+}
+
+func TestBuildBodyErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		tc   *test
+		err  string
+	}{
+		{"form needs an object", &test{BodyType: bodyForm, Body: mustDecode(t, `[1]`)}, "needs an object body, got array"},
+		{"multipart needs an object", &test{BodyType: bodyMultipart, Body: "x"}, "needs an object body, got string"},
+		{"file part without a path", &test{BodyType: bodyMultipart, Body: mustDecode(t, `{"f": {"filename": "a"}}`)}, `needs a "file" path`},
+		{"missing file part", &test{BodyType: bodyMultipart, Body: mustDecode(t, `{"f": {"file": "missing.bin"}}`)}, "multipart field 'f'"},
+		{"unknown body_type", &test{BodyType: "xml", Body: "x"}, "unknown body_type 'xml'"},
+	}
+	for _, tt := range tests {
+		if _, _, err := tt.tc.buildBody(dir); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestBuildBodyRaw(t *testing.T) {
+	got := sendBody(t, &test{BodyRaw: "line 1\nline 2"}, "")
+	if ct := got.header.Get("Content-Type"); ct != "text/plain; charset=utf-8" || string(got.body) != "line 1\nline 2" {
+		t.Errorf("body_raw: got %q with Content-Type %s", got.body, ct)
+	}
+
+	// A Content-Type of the test wins over the default one
+	got = sendBody(t, &test{BodyRaw: "<user/>", Header: map[string]string{"Content-Type": "application/xml"}}, "")
+	if ct := got.header.Get("Content-Type"); ct != "application/xml" || string(got.body) != "<user/>" {
+		t.Errorf("body_raw with a Content-Type: got %q with Content-Type %s", got.body, ct)
+	}
+
+	got = sendBody(t, &test{BodyType: bodyRaw, Body: `{"not": "encoded"`}, "")
+	if ct := got.header.Get("Content-Type"); ct != "text/plain; charset=utf-8" || string(got.body) != `{"not": "encoded"` {
+		t.Errorf("body_type raw: got %q with Content-Type %s", got.body, ct)
+	}
+}
+
+func TestBuildBodyFile(t *testing.T) {
+	dir := t.TempDir()
+	binary := []byte("\x00\x01\xfe\xff$id$\r\n")
+	os.WriteFile(filepath.Join(dir, "payload.bin"), binary, 0o644)
+	os.WriteFile(filepath.Join(dir, "user.json"), []byte(`{"id": 1}`), 0o644)
+
+	// Sent as-is, with a known length rather than chunked
+	got := sendBody(t, &test{BodyFile: "payload.bin"}, dir)
+	if string(got.body) != string(binary) {
+		t.Errorf("body: got %q, want %q", got.body, binary)
+	}
+	if ct := got.header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("Content-Type: got %s", ct)
+	}
+	if got.contentLength != int64(len(binary)) || got.chunked {
+		t.Errorf("got Content-Length %d, chunked %v", got.contentLength, got.chunked)
+	}
+
+	got = sendBody(t, &test{BodyFile: filepath.Join(dir, "user.json")}, "elsewhere")
+	if ct := got.header.Get("Content-Type"); ct != "application/json" || string(got.body) != `{"id": 1}` {
+		t.Errorf("absolute body_file: got %q with Content-Type %s", got.body, ct)
+	}
+
+	got = sendBody(t, &test{BodyFile: "user.json", Header: map[string]string{"Content-Type": "application/vnd.api+json"}}, dir)
+	if ct := got.header.Get("Content-Type"); ct != "application/vnd.api+json" {
+		t.Errorf("Content-Type of the test: got %s", ct)
+	}
+
+	if _, _, err := (&test{BodyFile: "missing.bin"}).buildBody(dir); err == nil || !strings.Contains(err.Error(), "body_file") {
+		t.Errorf("missing body_file: got %v", err)
+	}
+	for _, tc := range []*test{
+		{BodyFile: "payload.bin", BodyRaw: "x"},
+		{Body: "x", BodyRaw: "y"},
+		{Body: "x", GraphQL: &graphqlOptions{Query: "{ me { id } }"}},
+	} {
+		if _, _, err := tc.buildBody(dir); err == nil || !strings.Contains(err.Error(), "only one of") {
+			t.Errorf("%+v: got %v", tc, err)
+		}
+	}
+}
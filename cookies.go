@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"slices"
+)
+
+// defaultJar is the jar shared by the tests of a suite with cookie_jar enabled.
+const defaultJar = "default"
+
+// cookieJars holds the cookie jars by name. They are created on first use and live for the whole run,
+// so a session cookie set by a login test is sent by the following tests.
+var cookieJars = map[string]*cookiejar.Jar{}
+
+// parseCookieJar resolves the cookie_jar option of a test to a jar name.
+// 1. true: the suite's default jar.
+// 2. A string: a named jar (e.g. "alice" and "bob" for two users).
+// 3. false or missing: no jar, cookies are neither stored nor sent.
+func parseCookieJar(option any) (string, bool) {
+	switch o := option.(type) {
+	case nil:
+		return "", true
+	case bool:
+		if o {
+			return defaultJar, true
+		}
+		return "", true
+	case string:
+		if o == "" {
+			return defaultJar, true
+		}
+		return o, true
+	default:
+		return "", false
+	}
+}
+
+// cookieJar returns the jar with the given name, or nil for "" (no jar).
+func cookieJar(name string) http.CookieJar {
+	if name == "" {
+		return nil
+	}
+	jar, ok := cookieJars[name]
+	if !ok {
+		// cookiejar.New only fails with invalid options
+		jar, _ = cookiejar.New(nil)
+		cookieJars[name] = jar
+	}
+	return jar
+}
+
+// responseCookies returns the cookies a test can assert on: the state of its jar for the
+// final URL when it uses one, otherwise the cookies set by the response.
+func responseCookies(jarName string, res *http.Response) map[string]string {
+	var cookies []*http.Cookie
+	if jar := cookieJar(jarName); jar != nil {
+		cookies = jar.Cookies(res.Request.URL)
+	} else {
+		cookies = res.Cookies()
+	}
+	values := make(map[string]string, len(cookies))
+	for _, c := range cookies {
+		values[c.Name] = c.Value
+	}
+	return values
+}
+
+// validateCookies checks the cookies against the expected_cookies configuration.
+// Like expected_headers, each expected value can be:
+// 1. A string: exact match or matcher (e.g. "regex:^[a-f0-9]{32}$").
+// 2. true: the cookie must be present (any value).
+// 3. false: the cookie must be absent (e.g. after a logout).
+func validateCookies(expected map[string]any, cookies map[string]string) bool {
+	success := true
+	for _, name := range slices.Sorted(maps.Keys(expected)) {
+		exp := expected[name]
+		value, present := cookies[name]
+		at := joinPath("cookies", name)
+
+		switch e := exp.(type) {
+		case bool:
+			if e && !present {
+				reportMismatch(at, presentValue, missingValue, "cookie should be present")
+				success = false
+			} else if !e && present {
+				reportMismatch(at, missingValue, value, "cookie should be absent")
+				success = false
+			}
+
+		case string:
+			if !present {
+				reportMismatch(at, e, missingValue, "missing expected cookie")
+				success = false
+				continue
+			}
+			if !validateBody(e, value, at, recordMismatch, matchMode{}) {
+				success = false
+			}
+
+		default:
+			reportMismatch(at, exp, value, fmt.Sprintf("unsupported expected value type %T", exp))
+			success = false
+		}
+	}
+	return success
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newSessionServer is a server with a cookie session: /login sets it, /me reports whether it was sent
+// and /logout expires it.
+func newSessionServer(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: r.URL.Query().Get("user"), Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark", Path: "/"})
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("session")
+		if err != nil {
+			http.Error(w, "anonymous", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(c.Value))
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Path: "/", MaxAge: -1})
+	})
+	server := httptest.NewServer(mux)
+	tb.Cleanup(server.Close)
+	return server
+}
+
+// useCookieJars starts the run without any jar.
+func useCookieJars() (restore func()) {
+	previous := cookieJars
+	cookieJars = map[string]*cookiejar.Jar{}
+	return func() { cookieJars = previous }
+}
+
+// getWithJar requests url with the named jar, as a test with that cookie_jar does.
+func getWithJar(tb testing.TB, jarName, url string) *http.Response {
+	tb.Helper()
+	client := &http.Client{Jar: cookieJar(jarName)}
+	res, err := client.Get(url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestParseCookieJar(t *testing.T) {
+	tests := []struct {
+		option any
+		want   string
+		ok     bool
+	}{
+		{nil, "", true},
+		{true, defaultJar, true},
+		{false, "", true},
+		{"", defaultJar, true},
+		{"alice", "alice", true},
+		{float64(1), "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := parseCookieJar(tt.option); got != tt.want || ok != tt.ok {
+			t.Errorf("%#v: got %q, %v, want %q, %v", tt.option, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCookieJarSession(t *testing.T) {
+	defer useCookieJars()()
+	server := newSessionServer(t)
+
+	// The session set by a login test is sent by the following tests using the same jar
+	res := getWithJar(t, defaultJar, server.URL+"/login?user=ada")
+	if got := responseCookies(defaultJar, res); !reflect.DeepEqual(got, map[string]string{"session": "ada", "theme": "dark"}) {
+		t.Errorf("cookies after login: got %v", got)
+	}
+	if res := getWithJar(t, defaultJar, server.URL+"/me"); res.StatusCode != http.StatusOK {
+		t.Errorf("the default jar must send the session, got %d", res.StatusCode)
+	}
+	// The jar state is reported even when the response sets no cookie
+	if got := responseCookies(defaultJar, getWithJar(t, defaultJar, server.URL+"/me")); got["session"] != "ada" {
+		t.Errorf("jar cookies: got %v", got)
+	}
+
+	// Named jars are separate sessions, and a test without a jar sends nothing
+	getWithJar(t, "bob", server.URL+"/login?user=bob")
+	if got := responseCookies("bob", getWithJar(t, "bob", server.URL+"/me")); got["session"] != "bob" {
+		t.Errorf("bob's jar: got %v", got)
+	}
+	if got := responseCookies(defaultJar, getWithJar(t, defaultJar, server.URL+"/me")); got["session"] != "ada" {
+		t.Errorf("the default jar must keep ada's session, got %v", got)
+	}
+	if res := getWithJar(t, "", server.URL+"/me"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a jar: got %d", res.StatusCode)
+	}
+	if cookieJar("") != nil {
+		t.Error("the empty jar name must mean no jar")
+	}
+
+	// Without a jar only the cookies of the response itself are reported
+	if got := responseCookies("", getWithJar(t, "", server.URL+"/login?user=eve")); !reflect.DeepEqual(got, map[string]string{"session": "eve", "theme": "dark"}) {
+		t.Errorf("response cookies: got %v", got)
+	}
+	if got := responseCookies("", getWithJar(t, "", server.URL+"/me")); len(got) != 0 {
+		t.Errorf("response without cookies: got %v", got)
+	}
+
+	res = getWithJar(t, defaultJar, server.URL+"/logout")
+	if got := responseCookies(defaultJar, res); !reflect.DeepEqual(got, map[string]string{"theme": "dark"}) {
+		t.Errorf("cookies after logout: got %v", got)
+	}
+	if res := getWithJar(t, defaultJar, server.URL+"/me"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("after logout: got %d", res.StatusCode)
+	}
+}
+
+func TestValidateCookies(t *testing.T) {
+	cookies := map[string]string{"session": "3f2a9c1b", "theme": "dark"}
+	tests := []struct {
+		name     string
+		expected string
+		want     bool
+		paths    []string
+	}{
+		{"exact value", `{"theme": "dark"}`, true, nil},
+		{"matcher", `{"session": "regex:^[a-f0-9]{8}$"}`, true, nil},
+		{"present", `{"session": true}`, true, nil},
+		{"absent", `{"csrf": false}`, true, nil},
+		{"wrong value", `{"theme": "light"}`, false, []string{"cookies.theme"}},
+		{"missing", `{"csrf": "x", "lang": true}`, false, []string{"cookies.csrf", "cookies.lang"}},
+		{"should be absent", `{"session": false}`, false, []string{"cookies.session"}},
+		{"unsupported value", `{"theme": 1}`, false, []string{"cookies.theme"}},
+	}
+	for _, tt := range tests {
+		current := &test{}
+		restore := useTest(current)
+		got := validateCookies(mustDecode(t, tt.expected).(map[string]any), cookies)
+		restore()
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		var paths []string
+		for _, m := range current.Mismatches {
+			paths = append(paths, m.Path)
+		}
+		if !reflect.DeepEqual(paths, tt.paths) {
+			t.Errorf("%s: got mismatches at %q, want %q", tt.name, paths, tt.paths)
+		}
+	}
+}
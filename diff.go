@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ANSI colours for the console diff
+const (
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorReset = "\033[0m"
+)
+
+// maxDiffLines caps the size of the inputs of unifiedDiff, which is quadratic.
+const maxDiffLines = 2000
+
+// bodyDiff builds a unified diff between expected_response and the actual body.
+// The actual body is first projected onto the shape of expected (see projectActual),
+// so keys that were not asked for and values that satisfied their matcher do not show up
+// as differences; only real mismatches do.
+func bodyDiff(expected, actual any, mode matchMode) []string {
+	projected := projectActual(expected, actual, mode)
+	return unifiedDiff(diffLines(expected), diffLines(projected))
+}
+
+// diffLines renders a value as the lines that are compared: indented JSON, or the raw text for strings.
+func diffLines(v any) []string {
+	if s, ok := v.(string); ok {
+		return strings.Split(s, "\n")
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return []string{fmt.Sprintf("%v", v)}
+	}
+	return strings.Split(string(b), "\n")
+}
+
+// projectActual returns a copy of actual shaped like expected:
+// 1. Objects keep only the expected keys (plus the extra keys in strict mode).
+// 2. Unordered arrays are reordered to line up with the expected items they matched.
+// 3. Leaves that satisfy the expectation are replaced by the expected value (e.g., the matcher string).
+func projectActual(expected, actual any, mode matchMode) any {
+	if expected == nil || validateBody(expected, actual, "", nil, mode) {
+		return expected
+	}
+
+	switch exp := expected.(type) {
+	case map[string]any:
+		if spec, ok := exp[modeKey].(string); ok {
+			mode, _ = parseMatchMode(spec, mode)
+		}
+		act, ok := actual.(map[string]any)
+		if !ok {
+			return actual
+		}
+		projected := map[string]any{}
+		if _, hasMode := exp[modeKey]; hasMode {
+			projected[modeKey] = exp[modeKey]
+		}
+		for k, vExp := range exp {
+			vAct, exists := act[k]
+			if vExp == absentMarker {
+				if !exists {
+					projected[k] = vExp
+				} else {
+					projected[k] = vAct
+				}
+				continue
+			}
+			if exists {
+				projected[k] = projectActual(vExp, vAct, mode)
+			}
+		}
+		if mode.Strict {
+			for k, vAct := range act {
+				if _, expectedKey := exp[k]; !expectedKey {
+					projected[k] = vAct
+				}
+			}
+		}
+		return projected
+
+	case []any:
+		var marker []any
+		if len(exp) > 0 {
+			if m, ok := exp[0].(string); ok && strings.HasPrefix(m, modeMarkerPrefix) {
+				mode, _ = parseMatchMode(strings.TrimPrefix(m, modeMarkerPrefix), mode)
+				marker, exp = exp[:1], exp[1:]
+			}
+		}
+		act, ok := actual.([]any)
+		if !ok {
+			return actual
+		}
+		projected := append([]any{}, marker...)
+		if mode.Ordered {
+			for i := range act {
+				if i < len(exp) {
+					projected = append(projected, projectActual(exp[i], act[i], mode))
+				} else if mode.ExactLength {
+					projected = append(projected, act[i])
+				}
+			}
+			return projected
+		}
+
+		// Line up every expected item with the actual item it matched
+		used := make([]bool, len(act))
+		items := make([]any, len(exp))
+		shown := make([]bool, len(exp))
+		for i, expItem := range exp {
+			for j, actItem := range act {
+				if !used[j] && validateBody(expItem, actItem, "", nil, mode) {
+					used[j], shown[i] = true, true
+					items[i] = expItem
+					break
+				}
+			}
+		}
+		// Expected items without a match are shown against the remaining actual items, in order
+		next := 0
+		for i := range exp {
+			if shown[i] {
+				continue
+			}
+			for next < len(act) && used[next] {
+				next++
+			}
+			if next < len(act) {
+				used[next], shown[i] = true, true
+				items[i] = projectActual(exp[i], act[next], mode)
+			}
+		}
+		for i, item := range items {
+			if shown[i] {
+				projected = append(projected, item)
+			}
+		}
+		if mode.ExactLength {
+			for j, actItem := range act {
+				if !used[j] {
+					projected = append(projected, actItem)
+				}
+			}
+		}
+		return projected
+
+	default:
+		return actual
+	}
+}
+
+// unifiedDiff returns a line diff of a and b: unchanged lines start with "  ",
+// lines only in a with "- " and lines only in b with "+ ".
+// It uses a longest common subsequence, so inputs are capped to maxDiffLines.
+func unifiedDiff(a, b []string) []string {
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return []string{fmt.Sprintf("(diff skipped, more than %d lines)", maxDiffLines)}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return out
+}
+
+// printDiff writes a diff to the console, coloured when stdout is a terminal.
+// It is not added to the test logs, the report renders t.BodyDiff itself.
+func printDiff(lines []string) {
+	color := useColor()
+	fmt.Println("--- expected")
+	fmt.Println("+++ actual")
+	for _, line := range lines {
+		switch {
+		case !color:
+			fmt.Println(line)
+		case strings.HasPrefix(line, "- "):
+			fmt.Println(colorRed + line + colorReset)
+		case strings.HasPrefix(line, "+ "):
+			fmt.Println(colorGreen + line + colorReset)
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+// useColor reports whether the console supports colours (a terminal, and NO_COLOR is not set).
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
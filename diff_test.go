@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectMismatches(t *testing.T) {
+	expected := mustDecode(t, `{
+		"user": {"id": 1, "name": "Ada", "email": "email", "role": "not:guest"},
+		"tags": ["$mode:ordered", "a", "b"],
+		"count": "gt:2",
+		"password": "$absent",
+		"created": "missing"
+	}`)
+	actual := mustDecode(t, `{
+		"user": {"id": "1", "name": "Bob", "email": "bob", "role": "guest"},
+		"tags": ["b"],
+		"count": 2,
+		"password": "x"
+	}`)
+	want := []mismatch{
+		{"count", `"gt:2"`, "2", "2 is not > 2"},
+		{"created", `"missing"`, missingValue, "missing expected key"},
+		{"password", `"$absent"`, `"x"`, "key should be absent"},
+		{"tags", "2", "1", "array is shorter than expected"},
+		{"tags[0]", `"a"`, `"b"`, "string mismatch"},
+		{"tags[1]", `"b"`, missingValue, "missing expected item"},
+		{"user.email", `"email"`, `"bob"`, "'bob' is not an email address"},
+		{"user.id", "1", `"1"`, "value mismatch (expected integer, got string)"},
+		{"user.name", `"Ada"`, `"Bob"`, "string mismatch"},
+		{"user.role", `"not:guest"`, `"guest"`, "value should not be 'guest'"},
+	}
+	if got := collectMismatches(expected, actual, "", matchMode{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestMismatchPaths(t *testing.T) {
+	tests := []struct {
+		expected string
+		actual   string
+		mode     matchMode
+		want     []string
+	}{
+		{`1`, `2`, matchMode{}, []string{"(root)"}},
+		{`{"a": {"b": [{"c": 1}]}}`, `{"a": {"b": [{"c": 2}]}}`, matchMode{Ordered: true}, []string{"a.b[0].c"}},
+		{`{"odd key": 1, "a.b": 2}`, `{"odd key": 2, "a.b": 3}`, matchMode{}, []string{"a.b", "odd key"}},
+		{`{"id": 1}`, `{"id": 1, "z": 0, "b": 0}`, matchMode{Strict: true}, []string{"b", "z"}},
+		{`[1, 2]`, `[1, 2, 3]`, matchMode{ExactLength: true}, []string{"(root)"}},
+		{`{"items": []}`, `{"items": {}}`, matchMode{}, []string{"items"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range collectMismatches(mustDecode(t, tt.expected), mustDecode(t, tt.actual), "", tt.mode) {
+			got = append(got, m.Path)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s against %s: got paths %q, want %q", tt.expected, tt.actual, got, tt.want)
+		}
+	}
+}
+
+func TestDisplayValue(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{"1", `"1"`},
+		{json.Number("1"), "1"},
+		{json.Number("1.50"), "1.5"},
+		{float64(2.25), "2.25"},
+		{nil, "null"},
+		{true, "true"},
+		{missingValue, missingValue},
+		{presentValue, presentValue},
+		{mustDecode(t, `{"a": [1, "x"]}`), `{"a":[1,"x"]}`},
+	}
+	for _, tt := range tests {
+		if got := displayValue(tt.value); got != tt.want {
+			t.Errorf("%#v: got %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestProjectActual(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		mode     matchMode
+		want     string
+	}{
+		{"matching value keeps the matcher", `{"id": "gt:0"}`, `{"id": 7}`, matchMode{}, `{"id": "gt:0"}`},
+		{"keys not asked for are dropped", `{"id": 1, "name": "a"}`, `{"id": 1, "name": "b", "x": 0}`, matchMode{}, `{"id": 1, "name": "b"}`},
+		{"strict keeps extra keys", `{"id": 1}`, `{"id": 2, "x": 0}`, matchMode{Strict: true}, `{"id": 2, "x": 0}`},
+		{"missing keys stay missing", `{"id": 1, "name": "a"}`, `{"id": 2}`, matchMode{}, `{"id": 2}`},
+		{"$absent", `{"id": 1, "secret": "$absent"}`, `{"id": 2, "secret": "s"}`, matchMode{}, `{"id": 2, "secret": "s"}`},
+		{"$absent satisfied", `{"id": 1, "secret": "$absent"}`, `{"id": 2}`, matchMode{}, `{"id": 2, "secret": "$absent"}`},
+		{"unordered items line up", `[{"id": 1}, {"id": 9}]`, `[{"id": 3, "x": 0}, {"id": 1, "x": 0}]`, matchMode{}, `[{"id": 1}, {"id": 3}]`},
+		{"exact_length shows extra items", `[1]`, `[2, 1]`, matchMode{ExactLength: true}, `[1, 2]`},
+		{"ordered", `["$mode:ordered", 1, 2]`, `[2, 1, 3]`, matchMode{}, `["$mode:ordered", 2, 1]`},
+		{"type change", `{"a": {"b": 1}}`, `{"a": [1]}`, matchMode{}, `{"a": [1]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := projectActual(mustDecode(t, tt.expected), mustDecode(t, tt.actual), tt.mode)
+			if want := mustDecode(t, tt.want); !jsonEqual(got, want) {
+				b, _ := json.Marshal(got)
+				t.Errorf("got %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []string
+	}{
+		{"x\ny", "x\ny", []string{"  x", "  y"}},
+		{"x\ny\nz", "x\nz", []string{"  x", "- y", "  z"}},
+		{"x\nz", "x\ny\nz", []string{"  x", "+ y", "  z"}},
+		{"a\nb", "c", []string{"- a", "- b", "+ c"}},
+		{"", "a", []string{"- ", "+ a"}},
+	}
+	for _, tt := range tests {
+		if got := unifiedDiff(strings.Split(tt.a, "\n"), strings.Split(tt.b, "\n")); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q vs %q: got %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+	long := make([]string, maxDiffLines+1)
+	if got := unifiedDiff(long, nil); len(got) != 1 || !strings.HasPrefix(got[0], "(diff skipped") {
+		t.Errorf("long input: got %d lines", len(got))
+	}
+}
+
+func TestBodyDiff(t *testing.T) {
+	expected := mustDecode(t, `{"id": "gt:0", "name": "Ada", "tags": ["a", "b"]}`)
+	actual := mustDecode(t, `{"id": 7, "name": "Bob", "tags": ["b", "c", "a"], "extra": true}`)
+	want := []string{
+		"  {",
+		`    "id": "gt:0",`,
+		`-   "name": "Ada",`,
+		`+   "name": "Bob",`,
+		`    "tags": [`,
+		`      "a",`,
+		`      "b"`,
+		"    ]",
+		"  }",
+	}
+	if got := bodyDiff(expected, actual, matchMode{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A string expected_response is diffed against the raw body, line by line
+	want = []string{"  line 1", "- line 2", "+ line two"}
+	if got := bodyDiff("line 1\nline 2", "line 1\nline two", matchMode{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("string body: got %q, want %q", got, want)
+	}
+}
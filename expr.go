@@ -0,0 +1,993 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The assert expression language is a small, side effect free language evaluated against the response.
+//
+// Names:     body, headers, status, duration (milliseconds), vars, and $variable$ references
+// Paths:     body.items[0].price, body["odd key"], headers.Location, body.items[*].price (all prices)
+// Literals:  12, 3.5, "text", 'text', true, false, null
+// Operators: || && ! == != < <= > >= + - * / % and parentheses
+// Functions: len, sum, min, max, avg, abs, round, exists, contains, matches, date, lower, upper
+//
+// Examples:
+//   body.total == sum(body.items[*].price)
+//   date(body.updated_at) >= date(body.created_at)
+//   body.id == $test_2_id$ && status == 201
+
+// assertResult is the outcome of a single assert expression, shown in the report.
+type assertResult struct {
+	Expr   string `json:"expr"`
+	Pass   bool   `json:"pass"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// exprContext holds the values assert expressions can refer to.
+type exprContext struct {
+	body     any
+	headers  http.Header
+	status   int
+	duration time.Duration
+	vars     variablesStruct
+}
+
+// exprUndefined is the value of a path that does not exist. Only exists() accepts it.
+type exprUndefined struct{ path string }
+
+// exprProjection is the result of a [*] wildcard. Member and index access apply to every item.
+type exprProjection []any
+
+// evaluateAssertions evaluates every assert expression of the current test and records the results.
+// It returns true when all of them passed.
+func evaluateAssertions(exprs []string, ctx *exprContext) bool {
+	success := true
+	for _, src := range exprs {
+		result := assertResult{Expr: src}
+		node, err := parseExpr(src)
+		if err != nil {
+			result.Detail = fmt.Sprintf("syntax error: %v", err)
+		} else {
+			result.Pass, result.Detail = evaluateAssertion(node, ctx)
+		}
+		if result.Pass {
+			LogMsg("[PASS] assert %s\n", src)
+		} else {
+			success = false
+			LogMsg("[FAIL] assert %s\n\t%s\n", src, result.Detail)
+		}
+		t.AssertResults = append(t.AssertResults, result)
+	}
+	return success
+}
+
+// evaluateAssertion evaluates a parsed assertion. On failure of a comparison,
+// the detail shows the value of both sides.
+func evaluateAssertion(node *exprNode, ctx *exprContext) (bool, string) {
+	value, err := node.eval(ctx)
+	if err != nil {
+		return false, err.Error()
+	}
+	pass, ok := value.(bool)
+	if !ok {
+		return false, fmt.Sprintf("expression must be true or false, got %s", exprDisplay(value))
+	}
+	if pass {
+		return true, ""
+	}
+	if node.kind == exprBinary && isComparison(node.op) {
+		// Literals are already visible in the expression, only show the computed sides
+		var parts []string
+		for _, side := range node.args {
+			if side.kind != exprLiteral {
+				v, _ := side.eval(ctx)
+				parts = append(parts, fmt.Sprintf("%s is %s", side.text, exprDisplay(v)))
+			}
+		}
+		if len(parts) > 0 {
+			return false, strings.Join(parts, ", ")
+		}
+	}
+	return false, "expression is false"
+}
+
+// --- Lexer ---
+
+type exprTokenKind int
+
+const (
+	tokEOF exprTokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokVariable
+	tokOp
+)
+
+type exprToken struct {
+	kind  exprTokenKind
+	text  string // operator, identifier, number literal, decoded string or variable name
+	start int    // offset in the source
+	end   int
+}
+
+// lexExpr splits an expression into tokens.
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == 'e' || src[i] == 'E' ||
+				(src[i] == '-' || src[i] == '+') && (src[i-1] == 'e' || src[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: src[start:i], start: start, end: i})
+
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(src) && src[i] != c {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: tokString, text: sb.String(), start: start, end: i})
+
+		case c == '$':
+			start := i
+			end := strings.IndexByte(src[i+1:], '$')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated variable at %d", start)
+			}
+			i += end + 2
+			tokens = append(tokens, exprToken{kind: tokVariable, text: src[start+1 : i-1], start: start, end: i})
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: src[start:i], start: start, end: i})
+
+		default:
+			start := i
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "."} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at %d", c, i)
+			}
+			i += len(op)
+			tokens = append(tokens, exprToken{kind: tokOp, text: op, start: start, end: i})
+		}
+	}
+	return append(tokens, exprToken{kind: tokEOF, start: len(src), end: len(src)}), nil
+}
+
+// --- Parser ---
+
+type exprKind int
+
+const (
+	exprLiteral exprKind = iota
+	exprName
+	exprVariable
+	exprMember
+	exprIndex
+	exprWildcard
+	exprCall
+	exprUnary
+	exprBinary
+)
+
+// exprNode is a node of the parsed expression tree.
+type exprNode struct {
+	kind  exprKind
+	op    string      // operator for unary/binary nodes
+	name  string      // name, variable, member or function name
+	value any         // literal value
+	args  []*exprNode // operands, call arguments, or [object, index]
+	text  string      // source text of the node, used in failure messages
+}
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	pos    int
+}
+
+// binaryPrecedence lists the binary operators from lowest to highest precedence.
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseExpr parses an assert expression.
+func parseExpr(src string) (*exprNode, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	node, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected '%s' at %d", tok.text, tok.start)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// isOp reports whether the next token is the operator op.
+func (p *exprParser) isOp(op string) bool {
+	tok := p.peek()
+	return tok.kind == tokOp && tok.text == op
+}
+
+func (p *exprParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected '%s' at %d, got '%s'", op, tok.start, tok.text)
+	}
+	p.next()
+	return nil
+}
+
+// parseBinary parses operators of the given precedence level and above.
+func (p *exprParser) parseBinary(level int) (*exprNode, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+	start := p.peek().start
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || !slices.Contains(binaryPrecedence[level], tok.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{kind: exprBinary, op: tok.text, args: []*exprNode{left, right}, text: p.textFrom(start)}
+	}
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	start := p.peek().start
+	if p.isOp("!") || p.isOp("-") {
+		op := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: exprUnary, op: op, args: []*exprNode{operand}, text: p.textFrom(start)}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a primary expression followed by .member, [index] and [*] accessors.
+func (p *exprParser) parsePostfix() (*exprNode, error) {
+	start := p.peek().start
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected name after '.' at %d", tok.start)
+			}
+			node = &exprNode{kind: exprMember, name: tok.text, args: []*exprNode{node}, text: p.textFrom(start)}
+		case p.isOp("["):
+			p.next()
+			if p.isOp("*") {
+				p.next()
+				if err := p.expectOp("]"); err != nil {
+					return nil, err
+				}
+				node = &exprNode{kind: exprWildcard, args: []*exprNode{node}, text: p.textFrom(start)}
+				continue
+			}
+			index, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			node = &exprNode{kind: exprIndex, args: []*exprNode{node, index}, text: p.textFrom(start)}
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		if _, err := strconv.ParseFloat(tok.text, 64); err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at %d", tok.text, tok.start)
+		}
+		// Kept as json.Number so large integers compare exactly with the body
+		return &exprNode{kind: exprLiteral, value: json.Number(tok.text), text: tok.text}, nil
+	case tokString:
+		return &exprNode{kind: exprLiteral, value: tok.text, text: p.src[tok.start:tok.end]}, nil
+	case tokVariable:
+		return &exprNode{kind: exprVariable, name: tok.text, text: p.src[tok.start:tok.end]}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return &exprNode{kind: exprLiteral, value: tok.text == "true", text: tok.text}, nil
+		case "null":
+			return &exprNode{kind: exprLiteral, value: nil, text: tok.text}, nil
+		}
+		if p.isOp("(") {
+			p.next()
+			var args []*exprNode
+			for !p.isOp(")") {
+				arg, err := p.parseBinary(0)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if !p.isOp(")") {
+					if err := p.expectOp(","); err != nil {
+						return nil, err
+					}
+				}
+			}
+			p.next()
+			if _, ok := exprFunctions[tok.text]; !ok {
+				return nil, fmt.Errorf("unknown function '%s'", tok.text)
+			}
+			return &exprNode{kind: exprCall, name: tok.text, args: args, text: p.textFrom(tok.start)}, nil
+		}
+		return &exprNode{kind: exprName, name: tok.text, text: tok.text}, nil
+	case tokOp:
+		if tok.text == "(" {
+			node, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+	if tok.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected '%s' at %d", tok.text, tok.start)
+}
+
+// textFrom returns the source text from start up to the last consumed token.
+func (p *exprParser) textFrom(start int) string {
+	end := start
+	if p.pos > 0 {
+		end = p.tokens[p.pos-1].end
+	}
+	return strings.TrimSpace(p.src[start:end])
+}
+
+// --- Evaluation ---
+
+func (n *exprNode) eval(ctx *exprContext) (any, error) {
+	switch n.kind {
+	case exprLiteral:
+		return n.value, nil
+
+	case exprVariable:
+		v, ok := ctx.vars[n.name]
+		if !ok {
+			return nil, fmt.Errorf("%s is not present in variables", n.name)
+		}
+		return v, nil
+
+	case exprName:
+		switch n.name {
+		case "body":
+			return ctx.body, nil
+		case "headers":
+			return ctx.headers, nil
+		case "status":
+			return ctx.status, nil
+		case "duration":
+			return float64(ctx.duration) / float64(time.Millisecond), nil
+		case "vars":
+			return map[string]any(ctx.vars), nil
+		}
+		return nil, fmt.Errorf("unknown name '%s' (use body, headers, status, duration or vars)", n.name)
+
+	case exprMember:
+		obj, err := n.args[0].eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return exprAccess(obj, n.name, n.text)
+
+	case exprIndex:
+		obj, err := n.args[0].eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		index, err := n.args[1].eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return exprAccess(obj, index, n.text)
+
+	case exprWildcard:
+		obj, err := n.args[0].eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch o := obj.(type) {
+		case []any:
+			return exprProjection(o), nil
+		case exprProjection:
+			// Flatten nested wildcards (e.g. body.groups[*].items[*])
+			var flat exprProjection
+			for _, item := range o {
+				if arr, ok := item.([]any); ok {
+					flat = append(flat, arr...)
+				}
+			}
+			return flat, nil
+		}
+		return nil, fmt.Errorf("%s: [*] needs an array, got %s", n.text, exprDisplay(obj))
+
+	case exprCall:
+		args := make([]any, len(n.args))
+		for i, a := range n.args {
+			v, err := a.eval(ctx)
+			if err != nil {
+				if n.name != "exists" {
+					return nil, err
+				}
+				// exists() is false for anything that cannot be resolved
+				v = exprUndefined{path: a.text}
+			}
+			args[i] = v
+		}
+		v, err := exprFunctions[n.name](args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", n.text, err)
+		}
+		return v, nil
+
+	case exprUnary:
+		v, err := n.args[0].eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: '!' needs true or false, got %s", n.text, exprDisplay(v))
+			}
+			return !b, nil
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("%s: '-' needs a number, got %s", n.text, exprDisplay(v))
+		}
+		return -f, nil
+
+	case exprBinary:
+		return n.evalBinary(ctx)
+	}
+	return nil, fmt.Errorf("invalid expression")
+}
+
+func (n *exprNode) evalBinary(ctx *exprContext) (any, error) {
+	left, err := n.args[0].eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s: '%s' needs true or false, got %s", n.args[0].text, n.op, exprDisplay(left))
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := n.args[1].eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s: '%s' needs true or false, got %s", n.args[1].text, n.op, exprDisplay(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.args[1].eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, side := range []any{left, right} {
+		if u, ok := side.(exprUndefined); ok {
+			return nil, fmt.Errorf("%s is undefined", u.path)
+		}
+	}
+
+	switch n.op {
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	}
+
+	// Strings: concatenation and lexicographic comparison (ISO 8601 dates compare correctly)
+	ls, lIsString := left.(string)
+	rs, rIsString := right.(string)
+	if lIsString && rIsString {
+		switch n.op {
+		case "+":
+			return ls + rs, nil
+		case "<":
+			return ls < rs, nil
+		case "<=":
+			return ls <= rs, nil
+		case ">":
+			return ls > rs, nil
+		case ">=":
+			return ls >= rs, nil
+		}
+	}
+
+	// Integers are ordered exactly, as exprEqual compares them
+	if isIntegerLiteral(left) && isIntegerLiteral(right) {
+		a, okA := new(big.Int).SetString(string(left.(json.Number)), 10)
+		b, okB := new(big.Int).SetString(string(right.(json.Number)), 10)
+		if okA && okB {
+			switch n.op {
+			case "<":
+				return a.Cmp(b) < 0, nil
+			case "<=":
+				return a.Cmp(b) <= 0, nil
+			case ">":
+				return a.Cmp(b) > 0, nil
+			case ">=":
+				return a.Cmp(b) >= 0, nil
+			}
+		}
+	}
+
+	l, okL := toFloat(left)
+	r, okR := toFloat(right)
+	if !okL || !okR {
+		return nil, fmt.Errorf("%s: '%s' needs numbers, got %s and %s", n.text, n.op, exprDisplay(left), exprDisplay(right))
+	}
+	switch n.op {
+	case "<":
+		return l < r && !exprClose(l, r), nil
+	case "<=":
+		return l <= r || exprClose(l, r), nil
+	case ">":
+		return l > r && !exprClose(l, r), nil
+	case ">=":
+		return l >= r || exprClose(l, r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("%s: division by zero", n.text)
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("%s: division by zero", n.text)
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+// exprAccess resolves obj.key or obj[index]. Access on a projection applies to every item.
+func exprAccess(obj any, key any, path string) (any, error) {
+	switch o := obj.(type) {
+	case exprUndefined:
+		return o, nil
+	case exprProjection:
+		var out exprProjection
+		for _, item := range o {
+			v, err := exprAccess(item, key, path)
+			if err != nil {
+				return nil, err
+			}
+			if _, undefined := v.(exprUndefined); !undefined {
+				out = append(out, v)
+			}
+		}
+		return out, nil
+	case http.Header:
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: header names must be strings", path)
+		}
+		values := o.Values(name)
+		if len(values) == 0 {
+			return exprUndefined{path: path}, nil
+		}
+		return strings.Join(values, ", "), nil
+	case map[string]any:
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: object keys must be strings, got %s", path, exprDisplay(key))
+		}
+		v, exists := o[name]
+		if !exists {
+			return exprUndefined{path: path}, nil
+		}
+		return v, nil
+	case []any:
+		f, ok := toFloat(key)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("%s: array index must be an integer, got %s", path, exprDisplay(key))
+		}
+		i := int(f)
+		if i < 0 {
+			// Negative indices count from the end
+			i += len(o)
+		}
+		if i < 0 || i >= len(o) {
+			return exprUndefined{path: path}, nil
+		}
+		return o[i], nil
+	}
+	return nil, fmt.Errorf("%s: cannot access %s on %s", path, exprDisplay(key), exprDisplay(obj))
+}
+
+// exprEpsilon is the relative tolerance of number comparisons when float_tolerance is not set,
+// so a computed sum such as 10.1 + 20.2 equals 30.3.
+const exprEpsilon = 1e-9
+
+// exprClose reports whether two numbers are equal within float_tolerance, or exprEpsilon without it.
+// Without float_tolerance whole numbers are exact, so dates a second apart never compare equal.
+func exprClose(a, b float64) bool {
+	tolerance := floatTolerance
+	if tolerance == 0 {
+		if a == math.Trunc(a) && b == math.Trunc(b) {
+			return a == b
+		}
+		tolerance = exprEpsilon * math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+	}
+	return math.Abs(a-b) <= tolerance
+}
+
+// exprEqual compares two values, numbers by value. Integers are compared exactly,
+// other numbers with exprClose.
+func exprEqual(a, b any) bool {
+	if isNumber(a) && isNumber(b) {
+		if isIntegerLiteral(a) && isIntegerLiteral(b) {
+			return numbersEqual(a, b, 0)
+		}
+		l, _ := toFloat(a)
+		r, _ := toFloat(b)
+		return exprClose(l, r)
+	}
+	if p, ok := a.(exprProjection); ok {
+		a = []any(p)
+	}
+	if p, ok := b.(exprProjection); ok {
+		b = []any(p)
+	}
+	return jsonEqual(a, b)
+}
+
+// exprListContains reports whether an array or projection holds a value equal to v.
+func exprListContains(items []any, v any) bool {
+	for _, item := range items {
+		if exprEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// exprDisplay renders a value for failure messages.
+func exprDisplay(v any) string {
+	switch val := v.(type) {
+	case exprUndefined:
+		return "undefined"
+	case exprProjection:
+		return scalarString([]any(val))
+	case http.Header:
+		return "headers"
+	case int:
+		return strconv.Itoa(val)
+	case string:
+		return strconv.Quote(val)
+	default:
+		return scalarString(v)
+	}
+}
+
+// isComparison reports whether op compares its operands.
+func isComparison(op string) bool {
+	return slices.Contains([]string{"==", "!=", "<", "<=", ">", ">="}, op)
+}
+
+// --- Functions ---
+
+// exprFunctions are the functions available in assert expressions.
+var exprFunctions = map[string]func(args []any) (any, error){
+	"len": func(args []any) (any, error) {
+		if err := exprArgCount(args, 1); err != nil {
+			return nil, err
+		}
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []any:
+			return float64(len(v)), nil
+		case exprProjection:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len needs a string, array or object, got %s", exprDisplay(args[0]))
+	},
+	"sum": func(args []any) (any, error) {
+		nums, err := exprNumbers(args)
+		if err != nil {
+			return nil, err
+		}
+		total := 0.0
+		for _, n := range nums {
+			total += n
+		}
+		return total, nil
+	},
+	"avg": func(args []any) (any, error) {
+		nums, err := exprNumbers(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(nums) == 0 {
+			return nil, fmt.Errorf("avg of an empty list")
+		}
+		total := 0.0
+		for _, n := range nums {
+			total += n
+		}
+		return total / float64(len(nums)), nil
+	},
+	"min": func(args []any) (any, error) {
+		nums, err := exprNumbers(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(nums) == 0 {
+			return nil, fmt.Errorf("min of an empty list")
+		}
+		m := nums[0]
+		for _, n := range nums[1:] {
+			m = math.Min(m, n)
+		}
+		return m, nil
+	},
+	"max": func(args []any) (any, error) {
+		nums, err := exprNumbers(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(nums) == 0 {
+			return nil, fmt.Errorf("max of an empty list")
+		}
+		m := nums[0]
+		for _, n := range nums[1:] {
+			m = math.Max(m, n)
+		}
+		return m, nil
+	},
+	"abs": func(args []any) (any, error) {
+		if err := exprArgCount(args, 1); err != nil {
+			return nil, err
+		}
+		n, ok := toFloat(args[0])
+		if !ok {
+			return nil, fmt.Errorf("abs needs a number, got %s", exprDisplay(args[0]))
+		}
+		return math.Abs(n), nil
+	},
+	"round": func(args []any) (any, error) {
+		// round(x) or round(x, decimals)
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("round takes 1 or 2 arguments")
+		}
+		n, ok := toFloat(args[0])
+		if !ok {
+			return nil, fmt.Errorf("round needs a number, got %s", exprDisplay(args[0]))
+		}
+		scale := 1.0
+		if len(args) == 2 {
+			d, ok := toFloat(args[1])
+			if !ok {
+				return nil, fmt.Errorf("round needs a number of decimals, got %s", exprDisplay(args[1]))
+			}
+			scale = math.Pow(10, d)
+		}
+		return math.Round(n*scale) / scale, nil
+	},
+	"exists": func(args []any) (any, error) {
+		if err := exprArgCount(args, 1); err != nil {
+			return nil, err
+		}
+		_, undefined := args[0].(exprUndefined)
+		return !undefined, nil
+	},
+	"contains": func(args []any) (any, error) {
+		if err := exprArgCount(args, 2); err != nil {
+			return nil, err
+		}
+		switch v := args[0].(type) {
+		case string:
+			s, ok := args[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("contains on a string needs a string, got %s", exprDisplay(args[1]))
+			}
+			return strings.Contains(v, s), nil
+		case []any:
+			return exprListContains(v, args[1]), nil
+		case exprProjection:
+			return exprListContains(v, args[1]), nil
+		case map[string]any:
+			key, ok := args[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("contains on an object needs a key, got %s", exprDisplay(args[1]))
+			}
+			_, exists := v[key]
+			return exists, nil
+		}
+		return nil, fmt.Errorf("contains needs a string, array or object, got %s", exprDisplay(args[0]))
+	},
+	"matches": func(args []any) (any, error) {
+		if err := exprArgCount(args, 2); err != nil {
+			return nil, err
+		}
+		s, ok1 := args[0].(string)
+		pattern, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("matches needs a string and a pattern")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	},
+	"date": func(args []any) (any, error) {
+		// date(s) parses an ISO 8601 date/time into milliseconds since the epoch, so dates can be compared and subtracted
+		if err := exprArgCount(args, 1); err != nil {
+			return nil, err
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("date needs a string, got %s", exprDisplay(args[0]))
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+			if d, err := time.Parse(layout, s); err == nil {
+				return float64(d.UnixMilli()), nil
+			}
+		}
+		return nil, fmt.Errorf("'%s' is not an ISO 8601 date", s)
+	},
+	"lower": func(args []any) (any, error) {
+		if err := exprArgCount(args, 1); err != nil {
+			return nil, err
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("lower needs a string, got %s", exprDisplay(args[0]))
+		}
+		return strings.ToLower(s), nil
+	},
+	"upper": func(args []any) (any, error) {
+		if err := exprArgCount(args, 1); err != nil {
+			return nil, err
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("upper needs a string, got %s", exprDisplay(args[0]))
+		}
+		return strings.ToUpper(s), nil
+	},
+}
+
+// exprArgCount checks the number of arguments of a function call.
+func exprArgCount(args []any, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("takes %d argument(s), got %d", n, len(args))
+	}
+	return nil
+}
+
+// exprNumbers flattens the arguments of sum/min/max/avg: either a list (array or [*] projection)
+// or several numbers.
+func exprNumbers(args []any) ([]float64, error) {
+	var items []any
+	if len(args) == 1 {
+		switch v := args[0].(type) {
+		case []any:
+			items = v
+		case exprProjection:
+			items = v
+		default:
+			items = args
+		}
+	} else {
+		items = args
+	}
+	nums := make([]float64, 0, len(items))
+	for _, item := range items {
+		n, ok := toFloat(item)
+		if !ok {
+			return nil, fmt.Errorf("expected numbers, got %s", exprDisplay(item))
+		}
+		nums = append(nums, n)
+	}
+	return nums, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exprTestContext(tb testing.TB) *exprContext {
+	tb.Helper()
+	body := mustDecode(tb, `{
+		"id": 42,
+		"big": 9007199254740993,
+		"total": 30.3,
+		"items": [{"price": 10.1, "tags": ["a", "b"]}, {"price": 20.2, "tags": ["c"]}],
+		"name": "Ada",
+		"created_at": "2024-01-01T10:00:00Z",
+		"updated_at": "2024-01-02T10:00:00Z",
+		"empty": null,
+		"odd key": true
+	}`)
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	return &exprContext{
+		body:     body,
+		headers:  headers,
+		status:   201,
+		duration: 120 * time.Millisecond,
+		vars:     variablesStruct{"user_id": mustDecode(tb, "42"), "limit": "Ada"},
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// Precedence and associativity
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 4 - 3 == 3", true},
+		{"12 / 2 / 3 == 2", true},
+		{"7 % 4 == 3", true},
+		{"-2 * 3 == -6", true},
+		{"- -2 == 2", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"1 < 2 == true", true},
+		{"2 + 3 > 4 && 1 == 1", true},
+		{"1 == 1 != false", true},
+
+		// Names and paths
+		{"status == 201", true},
+		{"status >= 200 && status < 300", true},
+		{"duration < 500", true},
+		{"body.id == 42", true},
+		{"body.id == 42.0", true},
+		{"body.big == 9007199254740993", true},
+		{"body.big != 9007199254740992", true},
+		{"body.items[0].price == 10.1", true},
+		{"body.items[-1].price == 20.2", true},
+		{"body[\"odd key\"]", true},
+		{"body.items[1 - 1].tags[1] == 'b'", true},
+		{"headers[\"content-type\"] == \"application/json\"", true},
+		{"headers.Missing == null", false},
+		{"body.empty == null", true},
+		{"body.id == $user_id$", true},
+		{"vars.limit == body.name", true},
+
+		// Numbers computed from decimals
+		{"body.total == sum(body.items[*].price)", true},
+		{"body.total <= sum(body.items[*].price)", true},
+		{"body.total >= sum(body.items[*].price)", true},
+		{"body.total != sum(body.items[*].price)", false},
+		{"0.1 + 0.2 == 0.3", true},
+		{"0.1 + 0.2 <= 0.3", true},
+		{"0.1 + 0.2 > 0.3", false},
+		{"0.1 + 0.2 < 0.3", false},
+		{"0.3 < 0.1 + 0.2", false},
+		{"0.1 + 0.2 >= 0.3", true},
+		{"0.3 < 0.30001", true},
+		{"1000000000 < 1000000001", true},
+		{"9007199254740993 > 9007199254740992", true},
+		{"date('2024-01-02T00:00:00Z') == date('2024-01-02T00:00:01Z')", false},
+		{"date('2024-01-02T00:00:00Z') < date('2024-01-02T00:00:01Z')", true},
+		{"body.total == 30.4", false},
+
+		// Strings
+		{"body.name + '!' == 'Ada!'", true},
+		{"body.created_at < body.updated_at", true},
+		{"'b' > 'a'", true},
+		{"'Ada' == \"Ada\"", true},
+		{"'it\\'s' == \"it's\"", true},
+
+		// Functions
+		{"len(body.items) == 2", true},
+		{"len(body.name) == 3", true},
+		{"len(body.items[*].tags) == 2", true},
+		{"sum(1, 2, 3) == 6", true},
+		{"avg(body.items[*].price) == 15.15", true},
+		{"min(body.items[*].price) == 10.1", true},
+		{"max(3, 9, 4) == 9", true},
+		{"abs(-4) == 4", true},
+		{"round(2.345, 2) == 2.35", true},
+		{"round(2.5) == 3", true},
+		{"exists(body.id)", true},
+		{"exists(body.missing)", false},
+		{"exists(body.missing.deeper)", false},
+		{"!exists(headers.Missing)", true},
+		{"contains(body.items[*].price, 20.2)", true},
+		{"contains(body.items[0].tags, 'c')", false},
+		{"contains(body.name, 'd')", true},
+		{"contains(body, 'odd key')", true},
+		{"matches(body.name, '^A[a-z]+$')", true},
+		{"date(body.updated_at) - date(body.created_at) == 86400000", true},
+		{"date('2024-01-02') > date('2024-01-01T23:59:59Z')", true},
+		{"lower(body.name) == 'ada' && upper(body.name) == 'ADA'", true},
+	}
+	ctx := exprTestContext(t)
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			node, err := parseExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			got, detail := evaluateAssertion(node, ctx)
+			if got != tt.want {
+				t.Errorf("got %v (%s), want %v", got, detail, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprFloatTolerance(t *testing.T) {
+	defer func(previous float64) { floatTolerance = previous }(floatTolerance)
+	ctx := exprTestContext(t)
+
+	tests := []struct {
+		tolerance float64
+		expr      string
+		want      bool
+	}{
+		{0, "body.total == 30.3005", false},
+		{0.001, "body.total == 30.3005", true},
+		{0.001, "body.total != 30.3005", false},
+		{0.001, "body.total >= 30.3005", true},
+		{0.001, "body.total <= 30.2995", true},
+		{0.001, "body.total < 30.3005", false},
+		{0.001, "body.total > 30.2995", false},
+		{0.001, "body.total < 30.31", true},
+		{0.001, "body.total == 30.31", false},
+		// Integers are always exact
+		{1, "body.big == 9007199254740994", false},
+	}
+	for _, tt := range tests {
+		floatTolerance = tt.tolerance
+		node, err := parseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", tt.expr, err)
+		}
+		if got, detail := evaluateAssertion(node, ctx); got != tt.want {
+			t.Errorf("%s with float_tolerance %v: got %v (%s), want %v", tt.expr, tt.tolerance, got, detail, tt.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", "expected ')' at 6"},
+		{"1 2", "unexpected '2' at 2"},
+		{"body.", "expected name after '.'"},
+		{"body.items[0", "expected ']'"},
+		{"body.items[*", "expected ']'"},
+		{"'open", "unterminated string at 0"},
+		{"$open", "unterminated variable at 0"},
+		{"1 # 2", "unexpected character '#' at 2"},
+		{"1.2.3 == 1", "invalid number '1.2.3'"},
+		{"nope(1)", "unknown function 'nope'"},
+		{"len(1 2)", "expected ','"},
+		{"== 1", "unexpected '==' at 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseExpr(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		detail string
+	}{
+		{"body.missing == 1", "body.missing is undefined"},
+		{"foo == 1", "unknown name 'foo'"},
+		{"$nope$ == 1", "nope is not present in variables"},
+		{"body.name * 2 == 1", "'*' needs numbers"},
+		{"1 / 0 == 1", "division by zero"},
+		{"5 % 0 == 1", "division by zero"},
+		{"body.id && true", "'&&' needs true or false"},
+		{"!body.id", "'!' needs true or false"},
+		{"-body.name == 1", "'-' needs a number"},
+		{"body.items[0.5] == 1", "array index must be an integer"},
+		{"body.name[*] == 1", "[*] needs an array"},
+		{"len(1) == 1", "len needs a string, array or object"},
+		{"len(1, 2) == 1", "takes 1 argument(s), got 2"},
+		{"avg(body.items[*].tags) == 1", "expected numbers"},
+		{"min(body.items[*].missing) == 1", "min of an empty list"},
+		{"date('yesterday') == 1", "is not an ISO 8601 date"},
+		{"matches(body.name, '(') ", "missing closing )"},
+		{"body.id + 1", "expression must be true or false, got 43"},
+	}
+	ctx := exprTestContext(t)
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			node, err := parseExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			pass, detail := evaluateAssertion(node, ctx)
+			if pass || !strings.Contains(detail, tt.detail) {
+				t.Errorf("got %v (%s), want a failure with %q", pass, detail, tt.detail)
+			}
+		})
+	}
+}
+
+func TestEvaluateAssertionDetail(t *testing.T) {
+	tests := []struct {
+		expr   string
+		detail string
+	}{
+		// Computed sides are shown, literals are not
+		{"body.id == 41", "body.id is 42"},
+		{"len(body.items) > len(body.name)", "len(body.items) is 2, len(body.name) is 3"},
+		{"body.name == 'Bob'", `body.name is "Ada"`},
+		{"1 == 2", "expression is false"},
+		{"exists(body.missing)", "expression is false"},
+	}
+	ctx := exprTestContext(t)
+	for _, tt := range tests {
+		node, err := parseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", tt.expr, err)
+		}
+		if pass, detail := evaluateAssertion(node, ctx); pass || detail != tt.detail {
+			t.Errorf("%s: got %v (%s), want a failure with %q", tt.expr, pass, detail, tt.detail)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// graphqlOptions turns a test into a GraphQL request: the query, its variables and operation
+// name are posted as a JSON body, a non-empty errors array fails the test and expected_response
+// is matched against data.
+type graphqlOptions struct {
+	// Query is the GraphQL document, or the path of a .graphql / .gql file relative to the test file
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operation_name,omitempty"`
+	// AllowErrors lets the response have errors, to test error cases with assert
+	AllowErrors bool `json:"allow_errors,omitempty"`
+}
+
+// loadQuery reads the query from its file when it is a path. Relative paths are resolved from dir.
+func (g *graphqlOptions) loadQuery(dir string) error {
+	ext := filepath.Ext(g.Query)
+	if ext != ".graphql" && ext != ".gql" || strings.ContainsAny(g.Query, "{\n") {
+		return nil
+	}
+	file := g.Query
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	g.Query = string(data)
+	return nil
+}
+
+// body returns the JSON request body of the GraphQL operation.
+func (g *graphqlOptions) body() ([]byte, error) {
+	request := map[string]any{"query": g.Query}
+	if g.Variables != nil {
+		request["variables"] = g.Variables
+	}
+	if g.OperationName != "" {
+		request["operationName"] = g.OperationName
+	}
+	return json.Marshal(request)
+}
+
+// checkResponse checks the errors of a GraphQL response and returns its data, which expected_response
+// is matched against. It returns false when the response is not a GraphQL result or has unexpected errors.
+func (g *graphqlOptions) checkResponse(body []byte) ([]byte, bool) {
+	decoded, err := decodeJSON(body)
+	result, isObject := decoded.(map[string]any)
+	if err != nil || !isObject {
+		LogMsg("[FAIL] GraphQL response is not a JSON object.\n")
+		return body, false
+	}
+
+	success := true
+	if errors, _ := result["errors"].([]any); len(errors) > 0 && !g.AllowErrors {
+		success = false
+		LogMsg("[FAIL] GraphQL response has errors (%d):\n", len(errors))
+		for _, e := range errors {
+			message := scalarString(e)
+			if obj, ok := e.(map[string]any); ok {
+				message = fmt.Sprintf("%v", obj["message"])
+				if p, ok := obj["path"]; ok {
+					message += fmt.Sprintf(" (path: %s)", scalarString(p))
+				}
+			}
+			LogMsg("\t- %s\n", message)
+		}
+		reportMismatch("errors", missingValue, errors, "GraphQL response has errors")
+	}
+
+	data, err := json.Marshal(result["data"])
+	if err != nil {
+		return body, false
+	}
+	return data, success
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGraphQLServer is a GraphQL endpoint stub: a query for "user" returns the user with the id of the
+// variables, any other query returns a null data with an error.
+func newGraphQLServer(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Query         string         `json:"query"`
+			Variables     map[string]any `json:"variables"`
+			OperationName string         `json:"operationName"`
+		}
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(request.Query, "user(") {
+			io.WriteString(w, `{"data": null, "errors": [{"message": "Cannot query field", "path": ["me"]}, "boom"]}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"user": map[string]any{"id": request.Variables["id"], "operation": request.OperationName},
+		}})
+	}))
+	tb.Cleanup(server.Close)
+	return server
+}
+
+// postGraphQL sends the GraphQL request of tc to url and returns the response body.
+func postGraphQL(tb testing.TB, tc *test, url string) []byte {
+	tb.Helper()
+	body, contentType, err := tc.buildBody("")
+	if err != nil {
+		tb.Fatalf("buildBody: %v", err)
+	}
+	res, err := http.Post(url, contentType, body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		tb.Fatalf("got %d: %s", res.StatusCode, data)
+	}
+	return data
+}
+
+func TestGraphQLBody(t *testing.T) {
+	g := &graphqlOptions{Query: "query GetUser($id: ID!) { user(id: $id) { id } }", Variables: map[string]any{"id": json.Number("7")}, OperationName: "GetUser"}
+	data, err := g.body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"operationName":"GetUser","query":"query GetUser($id: ID!) { user(id: $id) { id } }","variables":{"id":7}}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+	data, _ = (&graphqlOptions{Query: "{ me { id } }"}).body()
+	if want := `{"query":"{ me { id } }"}`; string(data) != want {
+		t.Errorf("without variables: got %s, want %s", data, want)
+	}
+}
+
+func TestGraphQLLoadQuery(t *testing.T) {
+	dir := t.TempDir()
+	query := "query GetUser($id: ID!) {\n  user(id: $id) { id }\n}\n"
+	os.WriteFile(filepath.Join(dir, "user.graphql"), []byte(query), 0o644)
+
+	tests := []struct {
+		query string
+		want  string
+		err   bool
+	}{
+		{"user.graphql", query, false},
+		{filepath.Join(dir, "user.graphql"), query, false},
+		{"{ me { id } }", "{ me { id } }", false},
+		// A document that happens to end like a file name is not read
+		{"{ file(name: \"a.gql\") }", "{ file(name: \"a.gql\") }", false},
+		{"missing.gql", "", true},
+	}
+	for _, tt := range tests {
+		g := &graphqlOptions{Query: tt.query}
+		err := g.loadQuery(dir)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got error %v", tt.query, err)
+			continue
+		}
+		if !tt.err && g.Query != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.query, g.Query, tt.want)
+		}
+	}
+}
+
+func TestGraphQLResponse(t *testing.T) {
+	server := newGraphQLServer(t)
+
+	// expected_response is matched against data
+	tc := &test{GraphQL: &graphqlOptions{Query: "query GetUser($id: ID!) { user(id: $id) { id } }", Variables: map[string]any{"id": json.Number("7")}, OperationName: "GetUser"}}
+	defer useTest(tc)()
+	data, ok := tc.GraphQL.checkResponse(postGraphQL(t, tc, server.URL))
+	if !ok {
+		t.Fatalf("unexpected failure: %q", tc.Logs)
+	}
+	actual, err := decodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validateBody(mustDecode(t, `{"user": {"id": 7, "operation": "GetUser"}}`), actual, "", nil, matchMode{}) {
+		t.Errorf("data: got %s", data)
+	}
+
+	// Errors fail the test and are reported, unless allow_errors is set
+	tc = &test{GraphQL: &graphqlOptions{Query: "{ me { id } }"}}
+	defer useTest(tc)()
+	body := postGraphQL(t, tc, server.URL)
+	if data, ok := tc.GraphQL.checkResponse(body); ok || string(data) != "null" {
+		t.Errorf("errors: got %s, %v", data, ok)
+	}
+	logs := strings.Join(tc.Logs, "")
+	if !strings.Contains(logs, "has errors (2)") || !strings.Contains(logs, `Cannot query field (path: ["me"])`) || !strings.Contains(logs, "- boom") {
+		t.Errorf("logs: %q", tc.Logs)
+	}
+	if len(tc.Mismatches) != 1 || tc.Mismatches[0].Path != "errors" {
+		t.Errorf("mismatches: %+v", tc.Mismatches)
+	}
+
+	tc.GraphQL.AllowErrors = true
+	tc.Mismatches = nil
+	if _, ok := tc.GraphQL.checkResponse(body); !ok || len(tc.Mismatches) != 0 {
+		t.Errorf("allow_errors: got %v with %+v", ok, tc.Mismatches)
+	}
+
+	for _, body := range []string{`[1]`, `not json`, `{"data": {}, "errors": []}`} {
+		_, ok := tc.GraphQL.checkResponse([]byte(body))
+		if want := strings.HasPrefix(body, "{"); ok != want {
+			t.Errorf("%s: got %v, want %v", body, ok, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// validateHeaders checks the response headers against the expected_headers configuration.
+// Header names are case-insensitive. Each expected value can be:
+// 1. A string: exact match or "regex:" pattern (same matcher as validateBody).
+// 2. An array of strings: every item must match one of the header values (order does not matter).
+// 3. true: the header must be present (any value).
+// 4. false: the header must be absent.
+// For multi-value headers (e.g., Set-Cookie) a string passes if any of the values matches.
+// All headers are checked so every mismatch is logged, not only the first one.
+func validateHeaders(expected map[string]any, actual http.Header) bool {
+	success := true
+	for _, name := range slices.Sorted(maps.Keys(expected)) {
+		exp := expected[name]
+		values := actual.Values(name)
+		at := joinPath("headers", name)
+
+		switch e := exp.(type) {
+		case bool:
+			if e && len(values) == 0 {
+				reportMismatch(at, presentValue, missingValue, "header should be present")
+				success = false
+			} else if !e && len(values) > 0 {
+				reportMismatch(at, missingValue, strings.Join(values, ", "), "header should be absent")
+				success = false
+			}
+
+		case string:
+			if len(values) == 0 {
+				reportMismatch(at, e, missingValue, "missing expected header")
+				success = false
+				continue
+			}
+			if len(values) == 1 {
+				if !validateBody(e, values[0], at, recordMismatch, matchMode{}) {
+					success = false
+				}
+				continue
+			}
+			// Multi-value header: try each value silently first
+			found := false
+			for _, v := range values {
+				if validateBody(e, v, at, nil, matchMode{}) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				reportMismatch(at, e, strings.Join(values, ", "), "none of the header values matched")
+				success = false
+			}
+
+		case []any:
+			if len(values) == 0 {
+				reportMismatch(at, e, missingValue, "missing expected header")
+				success = false
+				continue
+			}
+			// Reuse the unordered array matching of validateBody
+			actualValues := make([]any, len(values))
+			for i, v := range values {
+				actualValues[i] = v
+			}
+			if !validateBody(e, actualValues, at, recordMismatch, matchMode{}) {
+				success = false
+			}
+
+		default:
+			reportMismatch(at, exp, strings.Join(values, ", "), fmt.Sprintf("unsupported expected value type %T", exp))
+			success = false
+		}
+	}
+	return success
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// jwtHashes maps the supported JWT algorithms to their hash functions.
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// jwtTimeClaims are the claims that accept a duration relative to now (e.g. "exp": "1h").
+var jwtTimeClaims = []string{"exp", "nbf", "iat"}
+
+// mintJWT creates a signed JWT for the $jwt(ALG, key_file, claims_variable)$ placeholder, e.g.
+// $jwt(HS256, keys/dev.key, admin_claims)$. The claims are an object in variables and can use variables
+// themselves. exp, nbf and iat can be durations relative to now ("1h", "-5m"); iat defaults to now.
+// The key file is the shared secret for HS algorithms and a PEM private key for RS algorithms,
+// relative to the test file.
+func mintJWT(args string) (string, error) {
+	parts := strings.Split(args, ",")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("expected jwt(ALG, key_file, claims_variable)")
+	}
+	alg, keyFile, claimsName := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported algorithm '%s' (use HS256, HS384, HS512, RS256, RS384 or RS512)", alg)
+	}
+
+	claims, err := jwtClaims(claimsName)
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("claims: %v", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	if !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(filepath.Dir(*path), keyFile)
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("key: %v", err)
+	}
+
+	var signature []byte
+	if strings.HasPrefix(alg, "HS") {
+		// A trailing newline of the key file is not part of the secret
+		mac := hmac.New(hash.New, []byte(strings.TrimRight(string(key), "\r\n")))
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	} else {
+		privateKey, err := parseRSAKey(key)
+		if err != nil {
+			return "", fmt.Errorf("key: %v", err)
+		}
+		digest := hash.New()
+		digest.Write([]byte(signingInput))
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey, hash, digest.Sum(nil)); err != nil {
+			return "", err
+		}
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwtClaims returns a copy of the claims variable with its variables substituted and its time claims resolved.
+func jwtClaims(name string) (map[string]any, error) {
+	value, ok := variables[name]
+	if !ok {
+		return nil, fmt.Errorf("claims variable '%s' is not present in variables", name)
+	}
+	if _, isObject := value.(map[string]any); !isObject {
+		return nil, fmt.Errorf("claims variable '%s' is not an object", name)
+	}
+	// Copied so the variable keeps its placeholders for the next tokens
+	data, _ := json.Marshal(value)
+	decoded, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	claims := decoded.(map[string]any)
+	if !processMap(claims) {
+		return nil, fmt.Errorf("failed to process the claims")
+	}
+
+	now := time.Now()
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = now.Unix()
+	}
+	for _, name := range jwtTimeClaims {
+		s, isString := claims[name].(string)
+		if !isString {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("claim %s: '%s' is not a number or a duration", name, s)
+		}
+		claims[name] = now.Add(d).Unix()
+	}
+	return claims, nil
+}
+
+// parseRSAKey parses a PEM RSA private key in PKCS #1 or PKCS #8 form.
+func parseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA private key")
+	}
+	return rsaKey, nil
+}
+
+// decodeJWT returns the decoded header and claims of a JWT, without verifying its signature.
+func decodeJWT(token string) (map[string]any, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(token), "Bearer "), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("a JWT has 3 parts separated by dots, got %d", len(parts))
+	}
+	decoded := map[string]any{}
+	for i, name := range []string{"header", "claims"} {
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[i], "="))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if decoded[name], err = decodeJSON(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return decoded, nil
+}
+
+// decodeJWTFields replaces the JWTs at the decode_jwt paths of the body with {"header": ..., "claims": ...},
+// so expected_response, expected_schema and assert can check the claims. The path "" is a body that is
+// the token itself. A value that is not a JWT is left as-is.
+func decodeJWTFields(body []byte, paths []string) []byte {
+	var data any = strings.TrimSpace(string(body))
+	if decoded, err := decodeJSON(body); err == nil {
+		data = decoded
+	}
+
+	changed := false
+	for _, p := range paths {
+		data = updatePath(data, p, func(value any) (any, bool) {
+			token, isString := value.(string)
+			if !isString {
+				LogMsg("[NOTE] decode_jwt: '%s' is not a string.\n", p)
+				return value, true
+			}
+			decoded, err := decodeJWT(token)
+			if err != nil {
+				LogMsg("[NOTE] decode_jwt: '%s' is not a JWT: %v\n", p, err)
+				return value, true
+			}
+			changed = true
+			return decoded, true
+		})
+	}
+	if !changed {
+		return body
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	return encoded
+}
+
+// splitJWTStorePaths splits var_to_store into the paths read from the raw body and the paths into the
+// header or claims of a decode_jwt token (e.g. "access_token.claims.sub"), read from the decoded body.
+// A token itself is stored as sent, so it can be reused in a later request.
+func splitJWTStorePaths(toStore map[string]string, jwtPaths []string) (raw, decoded map[string]string) {
+	if len(jwtPaths) == 0 {
+		return toStore, nil
+	}
+	raw, decoded = map[string]string{}, map[string]string{}
+	for name, p := range toStore {
+		if isDecodedJWTPath(p, jwtPaths) {
+			decoded[name] = p
+		} else {
+			raw[name] = p
+		}
+	}
+	return raw, decoded
+}
+
+// isDecodedJWTPath reports whether p goes into the decoded header or claims of one of the tokens.
+func isDecodedJWTPath(p string, jwtPaths []string) bool {
+	for _, token := range jwtPaths {
+		prefix := token
+		if prefix != "" {
+			prefix += "."
+		}
+		for _, part := range []string{"header", "claims"} {
+			rest, found := strings.CutPrefix(p, prefix+part)
+			if found && (rest == "" || rest[0] == '.' || rest[0] == '[') {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// splitJWT returns the decoded header and claims of a token, and its signing input and signature.
+func splitJWT(tb testing.TB, token string) (header, claims map[string]any, signingInput string, signature []byte) {
+	tb.Helper()
+	decoded, err := decodeJWT(token)
+	if err != nil {
+		tb.Fatalf("decodeJWT(%s): %v", token, err)
+	}
+	i := strings.LastIndexByte(token, '.')
+	if signature, err = base64.RawURLEncoding.DecodeString(token[i+1:]); err != nil {
+		tb.Fatalf("signature: %v", err)
+	}
+	return decoded["header"].(map[string]any), decoded["claims"].(map[string]any), token[:i], signature
+}
+
+func TestMintJWTHMAC(t *testing.T) {
+	dir := t.TempDir()
+	defer useSuitePath(filepath.Join(dir, "suite.json"))()
+	defer useTest(&test{})()
+	os.WriteFile(filepath.Join(dir, "dev.key"), []byte("s3cr3t\n"), 0o600)
+	defer useVariables(map[string]any{
+		"user_id": json.Number("12345678901234567890"),
+		"claims":  mustDecode(t, `{"sub": "$user_id$", "role": "admin", "exp": "1h", "nbf": "-5m"}`),
+	})()
+
+	for alg, hash := range map[string]crypto.Hash{"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512} {
+		before := time.Now().Unix()
+		token, err := mintJWT(alg + ", dev.key, claims")
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		header, claims, signingInput, signature := splitJWT(t, token)
+		if !reflect.DeepEqual(header, map[string]any{"alg": alg, "typ": "JWT"}) {
+			t.Errorf("%s: header %v", alg, header)
+		}
+		// The trailing newline of the key file is not part of the secret
+		mac := hmac.New(hash.New, []byte("s3cr3t"))
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			t.Errorf("%s: invalid signature", alg)
+		}
+
+		if claims["sub"] != "12345678901234567890" || claims["role"] != "admin" {
+			t.Errorf("%s: claims %v", alg, claims)
+		}
+		iat, _ := claims["iat"].(json.Number).Int64()
+		exp, _ := claims["exp"].(json.Number).Int64()
+		nbf, _ := claims["nbf"].(json.Number).Int64()
+		if iat < before || exp-iat != 3600 || iat-nbf != 300 {
+			t.Errorf("%s: iat %d, exp %d, nbf %d", alg, iat, exp, nbf)
+		}
+	}
+
+	// The variable keeps its placeholders for the next tokens
+	if claims := variables["claims"].(map[string]any); claims["sub"] != "$user_id$" || claims["exp"] != "1h" {
+		t.Errorf("claims variable changed: %v", claims)
+	}
+}
+
+func TestMintJWTRSA(t *testing.T) {
+	dir := t.TempDir()
+	defer useSuitePath(filepath.Join(dir, "suite.json"))()
+	defer useTest(&test{})()
+	defer useVariables(map[string]any{"claims": mustDecode(t, `{"sub": "ada", "iat": 1700000000}`)})()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+	writePEM(t, dir, "pkcs1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	writePEM(t, dir, "pkcs8.pem", "PRIVATE KEY", pkcs8)
+
+	tests := []struct {
+		args string
+		alg  string
+		hash crypto.Hash
+	}{
+		{"RS256, pkcs1.pem, claims", "RS256", crypto.SHA256},
+		{"RS384, pkcs8.pem, claims", "RS384", crypto.SHA384},
+		{"RS512, " + filepath.Join(dir, "pkcs8.pem") + ", claims", "RS512", crypto.SHA512},
+	}
+	for _, tt := range tests {
+		token, err := mintJWT(tt.args)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.args, err)
+		}
+		header, claims, signingInput, signature := splitJWT(t, token)
+		if header["alg"] != tt.alg {
+			t.Errorf("%s: header %v", tt.args, header)
+		}
+		// An iat of the claims is kept
+		if !jsonEqual(claims, mustDecode(t, `{"sub": "ada", "iat": 1700000000}`)) {
+			t.Errorf("%s: claims %v", tt.args, claims)
+		}
+		digest := tt.hash.New()
+		digest.Write([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, tt.hash, digest.Sum(nil), signature); err != nil {
+			t.Errorf("%s: %v", tt.args, err)
+		}
+	}
+}
+
+func TestMintJWTErrors(t *testing.T) {
+	dir := t.TempDir()
+	defer useSuitePath(filepath.Join(dir, "suite.json"))()
+	defer useTest(&test{})()
+	os.WriteFile(filepath.Join(dir, "dev.key"), []byte("s3cr3t"), 0o600)
+	defer useVariables(map[string]any{
+		"claims":     mustDecode(t, `{"sub": "ada"}`),
+		"list":       mustDecode(t, `["a"]`),
+		"bad_exp":    mustDecode(t, `{"exp": "tomorrow"}`),
+		"bad_claims": mustDecode(t, `{"sub": "$missing$"}`),
+	})()
+
+	tests := []struct {
+		args string
+		err  string
+	}{
+		{"HS256, dev.key", "expected jwt(ALG, key_file, claims_variable)"},
+		{"ES256, dev.key, claims", "unsupported algorithm 'ES256'"},
+		{"HS256, dev.key, unknown", "claims variable 'unknown' is not present"},
+		{"HS256, dev.key, list", "claims variable 'list' is not an object"},
+		{"HS256, dev.key, bad_exp", "claim exp: 'tomorrow' is not a number or a duration"},
+		{"HS256, dev.key, bad_claims", "failed to process the claims"},
+		{"HS256, missing.key, claims", "key:"},
+		{"RS256, dev.key, claims", "key: no PEM private key found"},
+	}
+	for _, tt := range tests {
+		if _, err := mintJWT(tt.args); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestJWTPlaceholder(t *testing.T) {
+	dir := t.TempDir()
+	defer useSuitePath(filepath.Join(dir, "suite.json"))()
+	defer useTest(&test{})()
+	os.WriteFile(filepath.Join(dir, "dev.key"), []byte("s3cr3t"), 0o600)
+	defer useVariables(map[string]any{"claims": mustDecode(t, `{"sub": "ada"}`)})()
+
+	header, ok := processString("Bearer $jwt(HS256, dev.key, claims)$")
+	if !ok || !strings.HasPrefix(header, "Bearer ey") {
+		t.Fatalf("got %q, %v", header, ok)
+	}
+	if _, claims, _, _ := splitJWT(t, header); claims["sub"] != "ada" {
+		t.Errorf("claims: %v", claims)
+	}
+	if _, ok := processString("Bearer $jwt(HS256, dev.key, unknown)$"); ok {
+		t.Error("an invalid $jwt(...)$ must fail")
+	}
+}
+
+func TestDecodeJWT(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	token := encode(`{"alg":"HS256"}`) + "." + encode(`{"sub":"ada","id":12345678901234567890}`) + ".c2ln"
+
+	for _, input := range []string{token, "Bearer " + token, " " + token + "\n", token[:len(token)-5] + "==.c2ln"} {
+		decoded, err := decodeJWT(input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if want := mustDecode(t, `{"header": {"alg": "HS256"}, "claims": {"sub": "ada", "id": 12345678901234567890}}`); !jsonEqual(decoded, want) {
+			t.Errorf("%q: got %v", input, decoded)
+		}
+	}
+	for _, input := range []string{"", "a.b", "a.b.c.d", "!!!." + encode("{}") + ".x", encode("{}") + "." + encode("not json") + ".x"} {
+		if _, err := decodeJWT(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestDecodeJWTFields(t *testing.T) {
+	defer useTest(&test{})()
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	token := encode(`{"alg":"HS256"}`) + "." + encode(`{"sub":"ada"}`) + ".c2ln"
+	decoded := `{"header": {"alg": "HS256"}, "claims": {"sub": "ada"}}`
+
+	tests := []struct {
+		body  string
+		paths []string
+		want  string
+	}{
+		{`{"access_token": "` + token + `", "expires_in": 3600}`, []string{"access_token"}, `{"access_token": ` + decoded + `, "expires_in": 3600}`},
+		{`{"tokens": [{"t": "` + token + `"}, {"t": "` + token + `"}]}`, []string{"tokens[*].t"}, `{"tokens": [{"t": ` + decoded + `}, {"t": ` + decoded + `}]}`},
+		{`"` + token + `"`, []string{""}, decoded},
+		{token, []string{""}, decoded},
+		// Values that are not tokens are left as-is
+		{`{"access_token": "opaque", "n": 1}`, []string{"access_token", "n", "missing"}, `{"access_token": "opaque", "n": 1}`},
+	}
+	for _, tt := range tests {
+		got := decodeJWTFields([]byte(tt.body), tt.paths)
+		if !jsonEqual(mustDecode(t, string(got)), mustDecode(t, tt.want)) {
+			t.Errorf("%s: got %s, want %s", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestJWTStorePaths(t *testing.T) {
+	defer useTest(&test{})()
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	token := encode(`{"alg":"HS256"}`) + "." + encode(`{"sub":"ada","roles":["admin"]}`) + ".c2ln"
+	rawBody := []byte(`{"access_token": "` + token + `", "claims": "plain"}`)
+	decodeJWTPaths := []string{"access_token"}
+
+	toStore := map[string]string{
+		"token": "access_token",
+		"sub":   "access_token.claims.sub",
+		"role":  "access_token.claims.roles[0]",
+		"alg":   "access_token.header.alg",
+		"other": "claims",
+	}
+	raw, decoded := splitJWTStorePaths(toStore, decodeJWTPaths)
+	if want := map[string]string{"token": "access_token", "other": "claims"}; !reflect.DeepEqual(raw, want) {
+		t.Errorf("raw paths: got %v, want %v", raw, want)
+	}
+	if len(decoded) != 3 {
+		t.Errorf("decoded paths: got %v", decoded)
+	}
+	if raw, decoded := splitJWTStorePaths(toStore, nil); !reflect.DeepEqual(raw, toStore) || decoded != nil {
+		t.Errorf("without decode_jwt: got %v and %v", raw, decoded)
+	}
+	if _, decoded := splitJWTStorePaths(map[string]string{"sub": "claims.sub", "c": "claimsx"}, []string{""}); !reflect.DeepEqual(decoded, map[string]string{"sub": "claims.sub"}) {
+		t.Errorf("token body: got %v", decoded)
+	}
+
+	// The token is stored as sent, so it can be sent again, and its claims from the decoded body
+	defer useVariables(map[string]any{"test_4_token": nil, "test_4_sub": nil, "test_4_role": nil, "test_4_alg": nil, "test_4_other": nil})()
+	storeBodyVariables(4, rawBody, variables, raw)
+	storeBodyVariables(4, decodeJWTFields(rawBody, decodeJWTPaths), variables, decoded)
+	want := map[string]any{"test_4_token": token, "test_4_sub": "ada", "test_4_role": "admin", "test_4_alg": "HS256", "test_4_other": "plain"}
+	for name, value := range want {
+		if variables[name] != value {
+			t.Errorf("%s: got %#v, want %#v", name, variables[name], value)
+		}
+	}
+}
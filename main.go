@@ -1,14 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -17,6 +18,10 @@ func main() {
 	path = flag.String("path", "./test.json", "path of the test json file")
 	output_dir = flag.String("output_dir", "./reports", "directory path for the report. Default: ./reports")
 	template_file = flag.String("template", "./template.html", "template refers to template.html file path from which reports are generated. Default: ./template.html")
+	update_snapshots = flag.Bool("update-snapshots", false, "record the snapshots of snapshot tests again instead of comparing against them")
+	proxy = flag.String("proxy", "", "URL of the proxy for every request (e.g. http://127.0.0.1:8888). Overrides the proxy of the suite")
+	unix_socket = flag.String("unix-socket", "", "path of a Unix domain socket every request is sent to. Overrides the unix_socket of the suite")
+	flag.Var(&resolve, "resolve", "pin a host to an address, as host:port:address (can be repeated)")
 	flag.Parse()
 
 	// Initialize execution variables
@@ -36,11 +41,21 @@ func main() {
 	defer file.Close()
 
 	// 2. Decode the JSON content into the struct
-	if err := json.NewDecoder(file).Decode(&input); err != nil {
+	// Numbers are kept as json.Number so large integers in expectations and variables stay exact
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	if err := decoder.Decode(&input); err != nil {
 		log.Fatalf("cannot decode test.json.\nErr:%v", err)
 	}
+	floatTolerance = input.FloatTolerance
 	fmt.Printf("\n\t--- Name: %v ---\n", input.Name)
 
+	// Apply the network flags over the suite options, then check them once as they are the same for every test
+	input.networkOptions.applyFlags()
+	if _, err := getTransport(tlsOptions{}, input.networkOptions, filepath.Dir(*path)); err != nil {
+		log.Fatalf("invalid network options.\nErr:%v", err)
+	}
+
 	// Load global variables defined in the config
 	storeGlobalVariables(variables, input.Variables)
 
@@ -61,6 +76,24 @@ func main() {
 		// Set the test number in the struct if not present (optional, but good for reporting)
 		t.Number = testNo
 
+		// Inherit the suite wide response time limit
+		if t.MaxDuration == "" {
+			t.MaxDuration = input.MaxDuration
+		}
+		// Inherit the suite wide cookie jar, unless the test opts out (false) or names its own jar
+		if t.CookieJar == nil && input.CookieJar {
+			t.CookieJar = true
+		}
+		// Inherit the suite auth and sign as copies, so their variables are substituted again for each test
+		if t.Auth == nil && input.Auth != nil {
+			auth := *input.Auth
+			t.Auth = &auth
+		}
+		if t.Sign == nil && input.Sign != nil {
+			sign := *input.Sign
+			t.Sign = &sign
+		}
+
 		// --- Variable Substitution & Pre-processing ---
 		if ok := t.preProcess(testNo); !ok {
 			failed++
@@ -69,31 +102,54 @@ func main() {
 
 		LogMsg("\n------------- Test %d: [%s] %s -------------\n\n", testNo, t.Method, t.Url)
 
-		var body io.Reader
-
 		// --- Request Construction ---
 
-		// 3.1 Convert body into io.Reader if body exists
-		if t.Body != nil {
-			jsonData, err := json.Marshal(t.Body)
-			if err != nil {
+		// 3.0 Resolve the transport (TLS options of the suite and the test, network options of the suite)
+		transport, err := getTransport(mergeTLS(input.TLS, t.TLS), input.networkOptions, filepath.Dir(*path))
+		if err != nil {
+			failed++
+			LogMsg("[FAIL] %v: Invalid tls options: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			continue
+		}
+
+		// WebSocket tests run their handshake and steps instead of a single request
+		if t.WebSocket != nil {
+			if t.runWebSocket(testNo, transport) {
+				passed++
+				t.Pass = true
+			} else {
 				failed++
-				LogMsg("[FAIL] %v: Invalid JSON body in test config: %v\n\n", testNo, err)
-				LogMsg("------------- Test %v Completed-------------\n\n", testNo)
-				continue
 			}
-			body = bytes.NewBuffer(jsonData)
+			t.TimeTaken = time.Since(testStart).String()
+			LogMsg("Test took %v\n", t.TimeTaken)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			continue
+		}
+
+		// 3.1 Encode the body (JSON, form, multipart, raw or a file) if body exists
+		body, contentType, err := t.buildBody(filepath.Dir(*path))
+		if err != nil {
+			failed++
+			LogMsg("[FAIL] %v: Invalid body in test config: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			continue
 		}
 
 		// 3.2 Create new request
 		req, err = http.NewRequest(t.Method, t.Url, body)
 		if err != nil {
+			if c, ok := body.(io.Closer); ok {
+				c.Close()
+			}
 			failed++
 			LogMsg("[FAIL] %v: Could not create request: %v\n\n", testNo, err)
 			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
 			continue
 		}
-		req.Header.Set("Content-Type", "application/json")
+		if length := bodyLength(body); length >= 0 {
+			req.ContentLength = length
+		}
 
 		// 3.3 Set custom headers if present
 		if t.Header != nil {
@@ -102,9 +158,41 @@ func main() {
 			}
 		}
 
+		// 3.4 Default Content-Type of the body, unless the test sets its own
+		if contentType != "" && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		// 3.5 Add the credentials of the auth option (fetching the OAuth2 token if needed)
+		if err := t.Auth.apply(req, transport); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			failed++
+			LogMsg("[FAIL] %v: Authentication failed: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			continue
+		}
+
+		// 3.6 Sign the request last, once its body and headers are final
+		if err := t.Sign.apply(req); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			failed++
+			LogMsg("[FAIL] %v: Request signing failed: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			continue
+		}
+
 		// --- Execution ---
 
-		// Do the http call
+		// Do the http call with the transport, cookie jar and redirect policy of the test, recording the timing phases
+		client.Transport = transport
+		client.Jar = cookieJar(t.jar)
+		client.CheckRedirect = t.checkRedirect
+		timing := &requestTiming{}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), timing.trace()))
 		res, err := client.Do(req)
 		if err != nil {
 			failed++
@@ -122,56 +210,195 @@ func main() {
 			res.Body.Close()
 			continue
 		}
+		timing.done()
+		t.Timing = timing
 		t.ActualStatus = res.Status
+		t.ActualStatusCode = res.StatusCode
+		t.ActualHeaders = res.Header
 		t.ActualResponse = string(actualBody)
+		t.FinalUrl = res.Request.URL.String()
+
+		// JWTs listed in decode_jwt are replaced by their header and claims for the body checks
+		checkedBody := actualBody
+		if len(t.DecodeJWT) > 0 {
+			checkedBody = decodeJWTFields(actualBody, t.DecodeJWT)
+		}
 
 		// --- Validation ---
 		// 1. Status Check
 		statusMatch := false
-		if res.Status != t.ExpectedStatus {
-			LogMsg("[FAIL] %v: Status Mismatch.\n\tExpected: %s\n\tGot:      %s\n", testNo, t.ExpectedStatus, res.Status)
+		if !matchStatus(t.ExpectedStatus, res.StatusCode) {
+			LogMsg("[FAIL] %v: Status Mismatch.\n\tExpected: %v\n\tGot:      %s\n", testNo, t.ExpectedStatus, res.Status)
 		} else {
 			statusMatch = true
 			LogMsg("[PASS] HTTP Status Matched.\n")
 		}
 
-		// 2. Body Check (Hybrid Validation)
+		// 2. Header Check
+		headersMatch := true
+		if t.ExpectedHeaders != nil {
+			if validateHeaders(t.ExpectedHeaders, res.Header) {
+				LogMsg("[PASS] Response Headers Matched.\n")
+			} else {
+				headersMatch = false
+				LogMsg("[FAIL] %v: Response Header Mismatch.\n", testNo)
+			}
+		}
+
+		// 3. Final URL Check (after redirects)
+		urlMatch := true
+		if t.ExpectedUrl != "" {
+			if validateBody(t.ExpectedUrl, t.FinalUrl, "url", recordMismatch, matchMode{}) {
+				LogMsg("[PASS] Final URL Matched.\n")
+			} else {
+				urlMatch = false
+				LogMsg("[FAIL] %v: Final URL Mismatch.\n", testNo)
+			}
+		}
+
+		// 4. Cookie Check
+		t.Cookies = responseCookies(t.jar, res)
+		cookiesMatch := true
+		if t.ExpectedCookies != nil {
+			if validateCookies(t.ExpectedCookies, t.Cookies) {
+				LogMsg("[PASS] Cookies Matched.\n")
+			} else {
+				cookiesMatch = false
+				LogMsg("[FAIL] %v: Cookie Mismatch.\n", testNo)
+			}
+		}
+
+		// In report_all mode a wrong status does not hide the body and schema failures
+		reportAll := input.ReportAll || t.ReportAll
+
+		// 5. Body Check (Hybrid Validation)
+		// A GraphQL response fails on errors, and expected_response and expected_schema are rooted at its data
 		bodyMatch := true
-		if statusMatch {
+		matchedBody := checkedBody
+		if statusMatch || reportAll {
+			if t.GraphQL != nil {
+				var ok bool
+				if matchedBody, ok = t.GraphQL.checkResponse(checkedBody); !ok {
+					bodyMatch = false
+				}
+			}
 			if t.ExpectedResponse != nil {
 				// CASE A: Expectation is a simple string (e.g., "Not an admin")
 				// We compare against the raw string body directly.
 				if _, isString := t.ExpectedResponse.(string); isString {
-					actualString := string(actualBody)
+					actualString := string(matchedBody)
 					// validateBody already handles string equality and "regex:" support
-					if validateBody(t.ExpectedResponse, actualString, false) {
+					if validateBody(t.ExpectedResponse, actualString, "", recordMismatch, t.mode) {
 						LogMsg("[PASS] Body String Match OK.\n")
 					} else {
 						bodyMatch = false
-						LogMsg("[FAIL] Body Mismatch.\n\tExpected: %v\n\tGot:      %v\n", t.ExpectedResponse, actualString)
+						LogMsg("[FAIL] Body Mismatch.\n")
+						t.BodyDiff = bodyDiff(t.ExpectedResponse, actualString, t.mode)
+						printDiff(t.BodyDiff)
 					}
 				} else {
 					// CASE B: Expectation is Complex (Map/Array)
 					// We must unmarshal the actual body to validate structure.
-					var actualJSON any
-					if err := json.Unmarshal(actualBody, &actualJSON); err != nil {
+					actualJSON, err := decodeJSON(matchedBody)
+					if err != nil {
 						bodyMatch = false
 						LogMsg("[FAIL] Response body is not valid JSON, cannot validate against expected structure.\n")
 					} else {
-						if validateBody(t.ExpectedResponse, actualJSON, false) {
+						before := len(t.Mismatches)
+						if validateBody(t.ExpectedResponse, actualJSON, "", recordMismatch, t.mode) {
 							LogMsg("[PASS] Body Subset Match OK.\n")
 						} else {
 							bodyMatch = false
-							LogMsg("[FAIL] Body Mismatch.\n")
+							LogMsg("[FAIL] Body Mismatch (%d problems).\n", len(t.Mismatches)-before)
+							t.BodyDiff = bodyDiff(t.ExpectedResponse, actualJSON, t.mode)
+							printDiff(t.BodyDiff)
 						}
 					}
 				}
 			}
 		} else {
-			LogMsg("[NOTE] Status did not match, skipping body validation.\n")
+			LogMsg("[NOTE] Status did not match, skipping body validation (set report_all to validate anyway).\n")
+		}
+
+		// 6. Schema Check
+		schemaMatch := true
+		if (statusMatch || reportAll) && t.ExpectedSchema != nil {
+			actualJSON, err := decodeJSON(matchedBody)
+			if err != nil {
+				schemaMatch = false
+				LogMsg("[FAIL] Response body is not valid JSON, cannot validate against expected_schema.\n")
+			} else if t.SchemaErrors = validateSchema(t.ExpectedSchema, actualJSON, filepath.Dir(*path)); len(t.SchemaErrors) > 0 {
+				schemaMatch = false
+				for _, e := range t.SchemaErrors {
+					LogMsg("[Schema Error] %s\n", e)
+				}
+				LogMsg("[FAIL] %v: Schema Mismatch (%d violations).\n", testNo, len(t.SchemaErrors))
+			} else {
+				LogMsg("[PASS] Schema Match OK.\n")
+			}
 		}
 
-		if !statusMatch || !bodyMatch {
+		// 7. XML / HTML Check
+		markupMatch := true
+		if statusMatch || reportAll {
+			if t.ExpectedXML != nil {
+				if validateMarkup(t.ExpectedXML, actualBody, false, xpathPrefix) {
+					LogMsg("[PASS] XML Match OK.\n")
+				} else {
+					markupMatch = false
+					LogMsg("[FAIL] %v: XML Mismatch.\n", testNo)
+				}
+			}
+			if t.ExpectedHTML != nil {
+				if validateMarkup(t.ExpectedHTML, actualBody, true, cssPrefix) {
+					LogMsg("[PASS] HTML Match OK.\n")
+				} else {
+					markupMatch = false
+					LogMsg("[FAIL] %v: HTML Mismatch.\n", testNo)
+				}
+			}
+		}
+
+		// 8. Snapshot Check
+		// A response with the wrong status is compared in report_all mode, but never recorded
+		snapshotMatch := true
+		if (statusMatch || reportAll) && t.Snapshot {
+			update := *update_snapshots && statusMatch
+			if checkSnapshot(input.Name, input.SnapshotIgnore, responseSnapshot(res, actualBody, t.SnapshotHeaders), update) {
+				LogMsg("[PASS] Snapshot Match OK.\n")
+			} else {
+				snapshotMatch = false
+				LogMsg("[FAIL] %v: Snapshot Mismatch. Run with -update-snapshots if the change is expected.\n", testNo)
+			}
+		}
+
+		// 9. Response Time Check
+		durationMatch := true
+		if t.maxDuration > 0 {
+			if timing.Total > t.maxDuration {
+				durationMatch = false
+				LogMsg("[FAIL] %v: Response took %v, max_duration is %v.\n", testNo, timing.Total, t.maxDuration)
+			} else {
+				LogMsg("[PASS] Response time %v within %v.\n", timing.Total, t.maxDuration)
+			}
+		}
+
+		// 10. Assertions
+		assertMatch := true
+		if len(t.Assert) > 0 {
+			// A body that is not JSON is exposed as a string
+			var assertBody any = string(checkedBody)
+			if decoded, err := decodeJSON(checkedBody); err == nil {
+				assertBody = decoded
+			}
+			ctx := &exprContext{body: assertBody, headers: res.Header, status: res.StatusCode, duration: timing.Total, vars: variables}
+			if !evaluateAssertions(t.Assert, ctx) {
+				assertMatch = false
+				LogMsg("[FAIL] %v: Assertion Failed.\n", testNo)
+			}
+		}
+
+		if !statusMatch || !headersMatch || !urlMatch || !cookiesMatch || !bodyMatch || !schemaMatch || !markupMatch || !snapshotMatch || !durationMatch || !assertMatch {
 			failed++
 		} else {
 			passed++
@@ -186,8 +413,14 @@ func main() {
 		// 	LogMsg("[PASS] Status OK.\n")
 		// }
 		//
-		// Store required body variables
-		if ok := storeBodyVariables(testNo, actualBody, variables, t.ToStore); !ok {
+		// Store required body variables. Tokens listed in decode_jwt are stored as sent,
+		// paths into their header or claims (e.g. "access_token.claims.sub") read the decoded body
+		rawStore, decodedStore := splitJWTStorePaths(t.ToStore, t.DecodeJWT)
+		stored := storeBodyVariables(testNo, actualBody, variables, rawStore)
+		if !storeBodyVariables(testNo, checkedBody, variables, decodedStore) {
+			stored = false
+		}
+		if !stored {
 			LogMsg("[NOTE] %v: Failed to store body variables.\n\n", testNo)
 		} else if len(t.ToStore) > 0 {
 			LogMsg("Variables stored successfully.\n")
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// markupNode is a node of a parsed XML or HTML document.
+// The document itself is the root node, with an empty name.
+type markupNode struct {
+	name     string            // element local name (lower case for HTML), "" for text and the document
+	isText   bool              // text (or CDATA) node, see text
+	text     string            // content of a text node
+	attrs    map[string]string // attributes by local name
+	children []*markupNode
+	parent   *markupNode
+}
+
+// Prefixes of the var_to_store paths that extract from XML/HTML bodies instead of JSON
+const (
+	xpathPrefix = "xpath:"
+	cssPrefix   = "css:"
+)
+
+// parseMarkup parses an XML document, or an HTML page when html is true.
+// HTML is parsed leniently: unclosed tags (<br>, <p>, ...) and HTML entities are accepted
+// and element names are lower-cased; the content of <script> and <style> is raw text. Namespace
+// prefixes are dropped, elements and attributes are matched by their local name.
+func parseMarkup(data []byte, html bool) (*markupNode, error) {
+	d := xmlDecoder(data, html)
+	root := &markupNode{}
+	current := root
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		var syntaxErr *xml.SyntaxError
+		if html && errors.As(err, &syntaxErr) && syntaxErr.Msg == "unexpected EOF" {
+			// An HTML page may end with open elements (e.g. no </body></html>)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tk := tok.(type) {
+		case xml.StartElement:
+			el := &markupNode{name: tk.Name.Local, attrs: map[string]string{}}
+			if html {
+				el.name = strings.ToLower(el.name)
+				current = closeImplied(current, el.name)
+			}
+			el.parent = current
+			for _, a := range tk.Attr {
+				name := a.Name.Local
+				if html {
+					name = strings.ToLower(name)
+				}
+				el.attrs[name] = a.Value
+			}
+			current.children = append(current.children, el)
+			current = el
+		case xml.EndElement:
+			// Close the nearest open element with that name. End tags of elements
+			// that were already closed implicitly are ignored.
+			for n := current; n.parent != nil; n = n.parent {
+				if strings.EqualFold(n.name, tk.Name.Local) {
+					current = n.parent
+					break
+				}
+			}
+		case xml.CharData:
+			if strings.TrimSpace(string(tk)) == "" {
+				continue
+			}
+			current.children = append(current.children, &markupNode{isText: true, text: string(tk), parent: current})
+		}
+	}
+	if len(root.elements()) == 0 {
+		return nil, errors.New("no element found")
+	}
+	return root, nil
+}
+
+// htmlImpliedEnd lists, for HTML elements whose end tag is optional, the start tags that close them
+// (e.g. a new <li> ends the previous one).
+var htmlImpliedEnd = map[string][]string{
+	"li":     {"li"},
+	"dt":     {"dt", "dd"},
+	"dd":     {"dt", "dd"},
+	"tr":     {"tr", "tbody", "tfoot"},
+	"td":     {"td", "th", "tr", "tbody", "tfoot"},
+	"th":     {"td", "th", "tr", "tbody", "tfoot"},
+	"option": {"option", "optgroup"},
+	"p": {"address", "article", "aside", "blockquote", "div", "dl", "fieldset", "footer", "form", "h1", "h2", "h3",
+		"h4", "h5", "h6", "header", "hr", "main", "nav", "ol", "p", "pre", "section", "table", "ul"},
+}
+
+// closeImplied closes the open elements that the start tag name implicitly ends and returns the new current element.
+func closeImplied(current *markupNode, name string) *markupNode {
+	for current.parent != nil && slices.Contains(htmlImpliedEnd[current.name], name) {
+		current = current.parent
+	}
+	return current
+}
+
+// isHTML reports whether a body should be parsed as HTML, from its Content-Type or its content.
+func isHTML(contentType string, body []byte) bool {
+	if strings.Contains(contentType, "html") {
+		return true
+	}
+	if strings.Contains(contentType, "xml") {
+		return false
+	}
+	start := strings.ToLower(string(bytes.TrimSpace(body[:min(len(body), 64)])))
+	return strings.HasPrefix(start, "<!doctype html") || strings.HasPrefix(start, "<html")
+}
+
+// elements returns the element children of a node.
+func (n *markupNode) elements() []*markupNode {
+	var out []*markupNode
+	for _, c := range n.children {
+		if !c.isText {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// descendants returns every element below a node, in document order.
+func (n *markupNode) descendants() []*markupNode {
+	var out []*markupNode
+	for _, c := range n.elements() {
+		out = append(out, c)
+		out = append(out, c.descendants()...)
+	}
+	return out
+}
+
+// textContent returns the text of a node and its descendants, with whitespace collapsed.
+func (n *markupNode) textContent() string {
+	var sb strings.Builder
+	var walk func(node *markupNode)
+	walk = func(node *markupNode) {
+		if node.isText {
+			sb.WriteString(node.text)
+			sb.WriteByte(' ')
+			return
+		}
+		for _, c := range node.children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// markupValue is the value of a query result: the text of an element, or an attribute/text() string.
+func markupValue(v any) string {
+	if n, ok := v.(*markupNode); ok {
+		return n.textContent()
+	}
+	return fmt.Sprint(v)
+}
+
+// queryMarkup runs an "xpath:" or "css:" query on a parsed document.
+// It returns the matched values (strings, or a json.Number for count()).
+func queryMarkup(doc *markupNode, kind, query string) ([]any, error) {
+	var results []any
+	var err error
+	if kind == xpathPrefix {
+		results, err = evalXPath(doc, query)
+	} else {
+		results, err = selectCSS(doc, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(results))
+	for i, r := range results {
+		if n, ok := r.(json.Number); ok {
+			values[i] = n
+			continue
+		}
+		values[i] = markupValue(r)
+	}
+	return values, nil
+}
+
+// validateMarkup checks an XML or HTML body against expected_xml (XPath keys) or expected_html
+// (CSS selector keys). Like expected_headers, each expected value can be:
+// 1. A string (or number): the first match must equal it, matchers such as "regex:" are supported.
+// 2. An array: the values of all the matches, compared as in expected_response.
+// 3. true: the query must match something.
+// 4. false: the query must match nothing.
+func validateMarkup(expected map[string]any, body []byte, html bool, kind string) bool {
+	doc, err := parseMarkup(body, html)
+	if err != nil {
+		format := "XML"
+		if html {
+			format = "HTML"
+		}
+		LogMsg("[FAIL] Response body is not valid %s: %v\n", format, err)
+		return false
+	}
+
+	success := true
+	for _, query := range slices.Sorted(maps.Keys(expected)) {
+		exp := expected[query]
+		at := kind + query
+		values, err := queryMarkup(doc, kind, query)
+		if err != nil {
+			reportMismatch(at, exp, missingValue, fmt.Sprintf("invalid query: %v", err))
+			success = false
+			continue
+		}
+
+		switch e := exp.(type) {
+		case bool:
+			if e && len(values) == 0 {
+				reportMismatch(at, presentValue, missingValue, "query should match")
+				success = false
+			} else if !e && len(values) > 0 {
+				reportMismatch(at, missingValue, values[0], "query should not match")
+				success = false
+			}
+
+		case []any:
+			if !validateBody(e, values, at, recordMismatch, t.mode) {
+				success = false
+			}
+
+		default:
+			if len(values) == 0 {
+				reportMismatch(at, exp, missingValue, "query matched nothing")
+				success = false
+				continue
+			}
+			actual := values[0]
+			// Let numbers in the config compare with the element text (e.g. <total>42</total>)
+			if s, ok := actual.(string); ok && isNumber(exp) {
+				if n := json.Number(strings.TrimSpace(s)); jsonNumberValid(n) {
+					actual = n
+				}
+			}
+			if !validateBody(exp, actual, at, recordMismatch, t.mode) {
+				success = false
+			}
+		}
+	}
+	return success
+}
+
+// jsonNumberValid reports whether n is a valid number literal.
+func jsonNumberValid(n json.Number) bool {
+	_, err := n.Float64()
+	return err == nil
+}
+
+// extractMarkup resolves an "xpath:" or "css:" var_to_store path against a body.
+func extractMarkup(body []byte, path string) (any, bool) {
+	kind, query := xpathPrefix, strings.TrimPrefix(path, xpathPrefix)
+	if strings.HasPrefix(path, cssPrefix) {
+		kind, query = cssPrefix, strings.TrimPrefix(path, cssPrefix)
+	}
+
+	// XPath defaults to XML, CSS selectors to HTML. An XML parse error falls back to HTML.
+	doc, err := parseMarkup(body, kind == cssPrefix)
+	if err != nil && kind == xpathPrefix {
+		doc, err = parseMarkup(body, true)
+	}
+	if err != nil {
+		LogMsg("Path '%s' failed: body could not be parsed: %v\n", path, err)
+		return nil, false
+	}
+	values, err := queryMarkup(doc, kind, query)
+	if err != nil {
+		LogMsg("Path '%s' failed: %v\n", path, err)
+		return nil, false
+	}
+	if len(values) == 0 {
+		LogMsg("Path '%s' failed: nothing matched\n", path)
+		return nil, false
+	}
+	return values[0], true
+}
+
+// prettyMarkup re-indents an XML or HTML body for the report.
+// It returns false when the body is not markup or cannot be tokenized.
+func prettyMarkup(s string) (string, bool) {
+	body := bytes.TrimSpace([]byte(s))
+	if len(body) == 0 || body[0] != '<' {
+		return "", false
+	}
+	html := isHTML("", body)
+	d := xmlDecoder(body, html)
+
+	var sb strings.Builder
+	var open []string // names of the open elements
+	text := ""        // text of the element just opened, printed inline if it closes right after
+	justOpened := false
+	line := func(depth int, content string) {
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(strings.Repeat("  ", depth) + content)
+	}
+	flush := func() {
+		if text != "" {
+			line(len(open), text)
+			text = ""
+		}
+		justOpened = false
+	}
+
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", false
+		}
+		switch tk := tok.(type) {
+		case xml.StartElement:
+			flush()
+			name := qualifiedName(tk.Name)
+			if html {
+				name = strings.ToLower(name)
+				for len(open) > 0 && slices.Contains(htmlImpliedEnd[open[len(open)-1]], name) {
+					open = open[:len(open)-1]
+				}
+			}
+			var tag strings.Builder
+			tag.WriteString("<" + qualifiedName(tk.Name))
+			for _, a := range tk.Attr {
+				fmt.Fprintf(&tag, " %s=\"%s\"", qualifiedName(a.Name), escapeAttr(a.Value))
+			}
+			tag.WriteString(">")
+			line(len(open), tag.String())
+			if !(html && htmlVoidElements[name]) {
+				open = append(open, name)
+				justOpened = true
+			}
+
+		case xml.EndElement:
+			name := qualifiedName(tk.Name)
+			i := len(open) - 1
+			for i >= 0 && !strings.EqualFold(open[i], name) {
+				i--
+			}
+			if i < 0 {
+				// Void element or stray end tag
+				continue
+			}
+			if justOpened && i == len(open)-1 {
+				// <Name>Ada</Name> on a single line
+				sb.WriteString(text + "</" + name + ">")
+				text, justOpened = "", false
+				open = open[:i]
+				continue
+			}
+			flush()
+			open = open[:i]
+			line(len(open), "</"+name+">")
+
+		case xml.CharData:
+			content := strings.TrimSpace(string(tk))
+			if content == "" {
+				continue
+			}
+			if !html || len(open) == 0 || !slices.Contains(htmlRawTextElements, open[len(open)-1]) {
+				var escaped bytes.Buffer
+				xml.EscapeText(&escaped, []byte(content))
+				content = escaped.String()
+			}
+			if justOpened {
+				text += content
+				continue
+			}
+			line(len(open), content)
+
+		case xml.Comment:
+			flush()
+			line(len(open), "<!--"+string(tk)+"-->")
+		case xml.ProcInst:
+			flush()
+			line(len(open), "<?"+tk.Target+" "+string(tk.Inst)+"?>")
+		case xml.Directive:
+			flush()
+			line(len(open), "<!"+string(tk)+">")
+		}
+	}
+	flush()
+	return sb.String(), true
+}
+
+// qualifiedName renders a raw token name with its prefix (RawToken keeps the prefix in Space).
+func qualifiedName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// escapeAttr escapes an attribute value for display.
+func escapeAttr(s string) string {
+	return strings.NewReplacer("&", "&amp;", "\"", "&quot;", "<", "&lt;").Replace(s)
+}
+
+// xmlDecoder returns a decoder for an XML document, or a lenient one for an HTML page.
+func xmlDecoder(data []byte, html bool) *xml.Decoder {
+	if html {
+		data = escapeRawText(data)
+	}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = charsetReader
+	if html {
+		d.Strict = false
+		d.AutoClose = xml.HTMLAutoClose
+		d.Entity = xml.HTMLEntity
+	}
+	return d
+}
+
+// htmlRawTextElements are the HTML elements whose content is raw text, not markup.
+var htmlRawTextElements = []string{"script", "style"}
+
+// escapeRawText escapes the content of the <script> and <style> elements of an HTML page, so
+// the XML tokenizer reads "if (a < b && c)" as text instead of failing on it. The decoder turns
+// the entities back into the original characters.
+func escapeRawText(data []byte) []byte {
+	lower := bytes.ToLower(data)
+	var out bytes.Buffer
+	pos := 0
+	for {
+		start, name := -1, ""
+		for _, el := range htmlRawTextElements {
+			i := indexTag(lower[pos:], "<"+el)
+			if i >= 0 && (start < 0 || pos+i < start) {
+				start, name = pos+i, el
+			}
+		}
+		if start < 0 {
+			break
+		}
+		// Content starts after the ">" of the start tag
+		open := bytes.IndexByte(lower[start:], '>')
+		if open < 0 {
+			break
+		}
+		contentStart := start + open + 1
+		out.Write(data[pos:contentStart])
+		if lower[contentStart-2] == '/' {
+			// <script src="..." />
+			pos = contentStart
+			continue
+		}
+		contentEnd := len(data)
+		if end := indexTag(lower[contentStart:], "</"+name); end >= 0 {
+			contentEnd = contentStart + end
+		}
+		xml.EscapeText(&out, data[contentStart:contentEnd])
+		pos = contentEnd
+		if contentEnd < len(data) {
+			// The end tag is written with the case of the start tag, which the tokenizer requires (<SCRIPT> ... </script>)
+			out.WriteString("</")
+			out.Write(data[start+1 : start+1+len(name)])
+			pos += len("</") + len(name)
+		}
+	}
+	if pos == 0 {
+		return data
+	}
+	out.Write(data[pos:])
+	return out.Bytes()
+}
+
+// indexTag returns the index of the tag opening prefix (e.g. "<script") in s, not followed by
+// a name character (so "<scripts" is not a script tag), or -1.
+func indexTag(s []byte, prefix string) int {
+	offset := 0
+	for {
+		i := bytes.Index(s[offset:], []byte(prefix))
+		if i < 0 {
+			return -1
+		}
+		next := offset + i + len(prefix)
+		if next == len(s) || strings.IndexByte(" \t\r\n/>", s[next]) >= 0 {
+			return offset + i
+		}
+		offset = next
+	}
+}
+
+// charsetReader converts the ISO-8859-1, windows-1252 and ASCII documents of legacy services to UTF-8.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "latin1":
+		return decodeSingleByte(input, nil)
+	case "windows-1252", "cp1252":
+		return decodeSingleByte(input, &windows1252Runes)
+	}
+	return nil, fmt.Errorf("unsupported charset '%s'", charset)
+}
+
+// decodeSingleByte decodes latin1 into UTF-8, with the bytes 0x80 to 0x9F taken from high when it is set.
+func decodeSingleByte(input io.Reader, high *[32]rune) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+		if high != nil && b >= 0x80 && b < 0xA0 {
+			runes[i] = high[b-0x80]
+		}
+	}
+	return strings.NewReader(string(runes)), nil
+}
+
+// windows1252Runes maps the bytes 0x80 to 0x9F of windows-1252, where it differs from latin1
+// (e.g. 0x80 is '€' and 0x93 a left double quote). Unassigned bytes keep their latin1 value.
+var windows1252Runes = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// htmlVoidElements are the HTML elements that never have a closing tag.
+var htmlVoidElements = map[string]bool{}
+
+func init() {
+	for _, name := range xml.HTMLAutoClose {
+		htmlVoidElements[name] = true
+	}
+}
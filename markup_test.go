@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testXML = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:u="urn:users">
+  <soap:Body>
+    <u:Users count="3">
+      <u:User id="1" role="admin"><u:Name>Ada</u:Name><u:Email>ada@example.com</u:Email></u:User>
+      <u:User id="2" role="user"><u:Name>Bob</u:Name></u:User>
+      <u:User id="3" role="user admin"><u:Name>Cy &amp; Co</u:Name><![CDATA[<raw>]]></u:User>
+    </u:Users>
+  </soap:Body>
+</soap:Envelope>`
+
+const testHTML = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Shop</title>
+  <style>ul > li { color: red }</style>
+  <script>if (items.length < 3 && ready) { render("</div>"); }</script>
+</head>
+<body>
+  <div id="main" class="page wide">
+    <h1>Products</h1>
+    <ul class="list">
+      <li class="item first" data-id="7"><a href="/p/7">Lamp</a>
+      <li class="item" data-id="8"><a href="/p/8" class="next">Desk</a>
+      <li class="item sold-out" data-id="9"><a href="/p/9">Chair</a>
+    </ul>
+    <p>Total<br>3 items
+    <p class="note">Prices &euro; incl. VAT</p>
+    <input type="submit" value="Buy" disabled>
+  </div>
+</body>
+</html>`
+
+// markupQuery runs a query on a parsed test document and returns the values as strings.
+func markupQuery(tb testing.TB, doc *markupNode, kind, query string) ([]string, error) {
+	tb.Helper()
+	values, err := queryMarkup(doc, kind, query)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for _, v := range values {
+		out = append(out, fmt.Sprint(v))
+	}
+	return out, nil
+}
+
+func TestEvalXPath(t *testing.T) {
+	doc, err := parseMarkup([]byte(testXML), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"/Envelope/Body/Users/User/Name", []string{"Ada", "Bob", "Cy & Co"}},
+		{"//User/Name", []string{"Ada", "Bob", "Cy & Co"}},
+		{"//user/name", []string{"Ada", "Bob", "Cy & Co"}},
+		{"//User/@id", []string{"1", "2", "3"}},
+		{"//Users/@count", []string{"3"}},
+		{"//User[1]/Name", []string{"Ada"}},
+		{"//User[last()]/@id", []string{"3"}},
+		{"//User[position() < 3]/@id", []string{"1", "2"}},
+		{"//User[@id='2']/Name", []string{"Bob"}},
+		{"//User[Name='Bob']/@role", []string{"user"}},
+		{"//User[Email]/Name", []string{"Ada"}},
+		{"//User[not(Email)]/@id", []string{"2", "3"}},
+		{"//User[contains(@role, 'admin')]/@id", []string{"1", "3"}},
+		{"//User[starts-with(Name, 'B') or @id='1']/@id", []string{"1", "2"}},
+		{"//User[@role='user' and Name='Bob']/@id", []string{"2"}},
+		{"//Name[text()='Ada']/../@id", []string{"1"}},
+		{"//User[3]/text()", []string{"<raw>"}},
+		{"(//Name)[2]", []string{"Bob"}},
+		{"//User[1]/*", []string{"Ada", "ada@example.com"}},
+		{"//User[1]/@*", []string{"1", "admin"}},
+		{"//User[1]/./Name", []string{"Ada"}},
+		{"count(//User)", []string{"3"}},
+		{"count(//Missing)", []string{"0"}},
+		{"//Missing", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := markupQuery(t, doc, xpathPrefix, tt.query)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalXPathErrors(t *testing.T) {
+	doc, err := parseMarkup([]byte(testXML), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"", "//User[", "//User[@id='1'", "//User]", "count(//User", "//User[foo(1)]", "//User/@"} {
+		if _, err := evalXPath(doc, query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestSelectCSS(t *testing.T) {
+	doc, err := parseMarkup([]byte(testHTML), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"title", []string{"Shop"}},
+		{"h1", []string{"Products"}},
+		{"#main h1", []string{"Products"}},
+		{"li", []string{"Lamp", "Desk", "Chair"}},
+		{"ul > li > a", []string{"Lamp", "Desk", "Chair"}},
+		{"div > a", []string{}},
+		{".item.first", []string{"Lamp"}},
+		{"li.sold-out a", []string{"Chair"}},
+		{"div.page.wide > h1", []string{"Products"}},
+		{"a@href", []string{"/p/7", "/p/8", "/p/9"}},
+		{"a.next@href", []string{"/p/8"}},
+		{"li[data-id]@data-id", []string{"7", "8", "9"}},
+		{"li[data-id=8]", []string{"Desk"}},
+		{"li[data-id='9']", []string{"Chair"}},
+		{`a[href^="/p/"]`, []string{"Lamp", "Desk", "Chair"}},
+		{"a[href$='8']", []string{"Desk"}},
+		{"a[href*=9]", []string{"Chair"}},
+		{"li[class~=sold-out]", []string{"Chair"}},
+		{"li:first-child", []string{"Lamp"}},
+		{"li:last-child", []string{"Chair"}},
+		{"li:nth-child(2)", []string{"Desk"}},
+		{"h1, .note", []string{"Products", "Prices € incl. VAT"}},
+		{"p", []string{"Total 3 items", "Prices € incl. VAT"}},
+		{"input[type=submit]@value", []string{"Buy"}},
+		{"input@disabled", []string{"disabled"}},
+		{"*#main > ul@class", []string{"list"}},
+		{"script", []string{`if (items.length < 3 && ready) { render("</div>"); }`}},
+		{"style", []string{"ul > li { color: red }"}},
+		{".missing", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := markupQuery(t, doc, cssPrefix, tt.query)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectCSSErrors(t *testing.T) {
+	doc, err := parseMarkup([]byte(testHTML), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"", "li[data-id", "li:hover", "li:nth-child(x)", "ul >", "a,", "li[data-id|=8]", "#", "li#", ".", "li.", "li..done", "li.#x"} {
+		if _, err := selectCSS(doc, query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestCharsetReader(t *testing.T) {
+	tests := []struct {
+		charset string
+		input   string
+		want    string
+	}{
+		{"ISO-8859-1", "caf\xe9 \x80", "café \u0080"},
+		{"latin1", "\xa3\xff", "£ÿ"},
+		{"windows-1252", "caf\xe9 \x80 \x93q\x94 \x85", "café € “q” …"},
+		{"CP1252", "\x8a\x81", "Š\u0081"},
+		{"us-ascii", "plain", "plain"},
+	}
+	for _, tt := range tests {
+		r, err := charsetReader(tt.charset, strings.NewReader(tt.input))
+		if err != nil {
+			t.Errorf("%s: %v", tt.charset, err)
+			continue
+		}
+		got, _ := io.ReadAll(r)
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.charset, got, tt.want)
+		}
+	}
+	if _, err := charsetReader("shift_jis", strings.NewReader("")); err == nil {
+		t.Error("shift_jis: expected an error")
+	}
+
+	doc, err := parseMarkup([]byte("<?xml version=\"1.0\" encoding=\"windows-1252\"?><p>\x93quoted\x94 \x80 5</p>"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := markupQuery(t, doc, xpathPrefix, "/p"); err != nil || len(got) != 1 || got[0] != "“quoted” € 5" {
+		t.Errorf("windows-1252 document: got %q (%v)", got, err)
+	}
+}
+
+func TestParseMarkupRawText(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want []string
+	}{
+		{"comparison and logical operators", `<html><body><script>if (a < b && c > d) {}</script><p>ok</p></body></html>`, []string{"if (a < b && c > d) {}"}},
+		{"end tag of another element", `<script>document.write("<p>x</p>")</script><p>ok</p>`, []string{`document.write("<p>x</p>")`}},
+		{"upper case tags", `<SCRIPT type="module">x <y</Script><p>ok</p>`, []string{"x <y"}},
+		{"self-closing script", `<head><script src="a.js"/><title>T</title></head><p>ok</p>`, []string{""}},
+		{"unterminated script", `<p>ok</p><script>if (a < b)`, []string{"if (a < b)"}},
+		{"not a script tag", `<scripts>a</scripts><p>ok</p>`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseMarkup([]byte(tt.page), true)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			got, err := markupQuery(t, doc, cssPrefix, "script")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if p, _ := markupQuery(t, doc, cssPrefix, "p"); len(p) > 0 && p[0] != "ok" {
+				t.Errorf("the rest of the page is not parsed: got %q", p)
+			}
+		})
+	}
+}
+
+func TestPrettyMarkup(t *testing.T) {
+	got, ok := prettyMarkup(`<html><head><script>if (a < b) {}</script></head><body><ul><li>A<li>B</ul><br></body></html>`)
+	if !ok {
+		t.Fatal("not pretty printed")
+	}
+	want := strings.Join([]string{
+		"<html>",
+		"  <head>",
+		"    <script>if (a < b) {}</script>",
+		"  </head>",
+		"  <body>",
+		"    <ul>",
+		"      <li>",
+		"        A",
+		"      <li>",
+		"        B",
+		"    </ul>",
+		"    <br>",
+		"  </body>",
+		"</html>",
+	}, "\n")
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// matcherFunc checks a decoded JSON value against a compiled expectation.
+// When the value does not match it returns a human readable reason.
+type matcherFunc func(actual any) (ok bool, reason string)
+
+// matcherFactory compiles the argument of a "name:arg" matcher (e.g. "0" in "gt:0").
+type matcherFactory func(arg string) (matcherFunc, error)
+
+// prefixMatchers holds the matchers written as "name:arg" in expected_response.
+// Use registerMatcher to add new ones.
+var prefixMatchers = map[string]matcherFactory{}
+
+// namedMatchers holds the matchers written as a keyword. Use registerNamedMatcher to add new ones.
+var namedMatchers = map[string]matcherFunc{}
+
+// compiledMatchers caches parsed matchers by their full expected string,
+// so a pattern used in many tests (or many array items) is only parsed once.
+var compiledMatchers = map[string]compiledMatcher{}
+
+// compiledMatcher is a cache entry. err is set when the matcher is registered but its argument is invalid.
+type compiledMatcher struct {
+	match matcherFunc
+	err   error
+}
+
+// registerMatcher adds a "name:arg" matcher to the registry.
+func registerMatcher(name string, factory matcherFactory) {
+	prefixMatchers[name] = factory
+}
+
+// registerNamedMatcher adds a keyword matcher to the registry.
+func registerNamedMatcher(name string, match matcherFunc) {
+	namedMatchers[name] = match
+}
+
+// lookupMatcher returns the compiled matcher for an expected string.
+// found is false when the string is not a matcher and should be compared literally.
+// Every matcher follows the same scheme: a registered keyword ("uuid", "$null") or a registered
+// "name:arg" ("gt:0"). "eq:" is the escape for literals of that form (e.g. "eq:any", "eq:type:admin").
+func lookupMatcher(exp string) (match matcherFunc, found bool, err error) {
+	if c, ok := compiledMatchers[exp]; ok {
+		return c.match, true, c.err
+	}
+
+	if m, ok := namedMatchers[exp]; ok {
+		compiledMatchers[exp] = compiledMatcher{match: m}
+		return m, true, nil
+	}
+
+	name, arg, hasArg := strings.Cut(exp, ":")
+	if !hasArg {
+		return nil, false, nil
+	}
+	factory, ok := prefixMatchers[name]
+	if !ok {
+		// e.g. "http://..." is a plain string, not a matcher
+		return nil, false, nil
+	}
+	m, err := factory(arg)
+	compiledMatchers[exp] = compiledMatcher{match: m, err: err}
+	return m, true, err
+}
+
+func init() {
+	// --- Presence ---
+	registerNamedMatcher("any", func(actual any) (bool, string) {
+		return true, ""
+	})
+	registerNamedMatcher(absentMarker, func(actual any) (bool, string) {
+		// Reaching a matcher means the value exists (e.g., "$absent" used as an array item)
+		return false, fmt.Sprintf("value should be absent, got %v", actual)
+	})
+	registerNamedMatcher(nullMarker, func(actual any) (bool, string) {
+		if actual != nil {
+			return false, fmt.Sprintf("expected null, got %v (%s)", actual, jsonType(actual))
+		}
+		return true, ""
+	})
+
+	// --- Formats ---
+	uuidPattern := regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	registerNamedMatcher("uuid", stringMatcher(func(s string) (bool, string) {
+		if !uuidPattern.MatchString(s) {
+			return false, fmt.Sprintf("'%s' is not a UUID", s)
+		}
+		return true, ""
+	}))
+	registerNamedMatcher("email", stringMatcher(func(s string) (bool, string) {
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s {
+			return false, fmt.Sprintf("'%s' is not an email address", s)
+		}
+		return true, ""
+	}))
+	registerNamedMatcher("iso8601", stringMatcher(func(s string) (bool, string) {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04:05Z0700", "2006-01-02"} {
+			if _, err := time.Parse(layout, s); err == nil {
+				return true, ""
+			}
+		}
+		return false, fmt.Sprintf("'%s' is not an ISO 8601 date/time", s)
+	}))
+
+	// --- Strings ---
+	// "eq:" compares the rest literally, for strings that would otherwise be read as a matcher
+	registerMatcher("eq", func(arg string) (matcherFunc, error) {
+		return func(actual any) (bool, string) {
+			s, isString := actual.(string)
+			if !isString {
+				return false, fmt.Sprintf("expected string, got %s", jsonType(actual))
+			}
+			if s != arg {
+				return false, "string mismatch"
+			}
+			return true, ""
+		}, nil
+	})
+	registerMatcher("regex", func(arg string) (matcherFunc, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return stringMatcher(func(s string) (bool, string) {
+			if !re.MatchString(s) {
+				return false, fmt.Sprintf("value '%s' did not match regex '%s'", s, arg)
+			}
+			return true, ""
+		}), nil
+	})
+	registerMatcher("contains", func(arg string) (matcherFunc, error) {
+		return func(actual any) (bool, string) {
+			switch act := actual.(type) {
+			case string:
+				if strings.Contains(act, arg) {
+					return true, ""
+				}
+			case []any:
+				for _, item := range act {
+					if scalarString(item) == arg {
+						return true, ""
+					}
+				}
+			default:
+				return false, fmt.Sprintf("expected string or array, got %s", jsonType(actual))
+			}
+			return false, fmt.Sprintf("%v does not contain '%s'", actual, arg)
+		}, nil
+	})
+	registerMatcher("oneof", func(arg string) (matcherFunc, error) {
+		options := strings.Split(arg, "|")
+		return func(actual any) (bool, string) {
+			value := scalarString(actual)
+			for _, o := range options {
+				if value == o {
+					return true, ""
+				}
+			}
+			return false, fmt.Sprintf("'%s' is not one of %v", value, options)
+		}, nil
+	})
+
+	// --- Negation ---
+	registerMatcher(strings.TrimSuffix(notPrefix, ":"), func(arg string) (matcherFunc, error) {
+		return func(actual any) (bool, string) {
+			if scalarString(actual) == arg {
+				return false, fmt.Sprintf("value should not be '%s'", arg)
+			}
+			return true, ""
+		}, nil
+	})
+	registerMatcher(strings.TrimSuffix(notRegexPrefix, ":"), func(arg string) (matcherFunc, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(actual any) (bool, string) {
+			if re.MatchString(scalarString(actual)) {
+				return false, fmt.Sprintf("value '%v' should not match regex '%s'", actual, arg)
+			}
+			return true, ""
+		}, nil
+	})
+
+	// --- Types ---
+	registerMatcher("type", func(arg string) (matcherFunc, error) {
+		switch arg {
+		case "string", "number", "integer", "boolean", "object", "array", "null":
+		default:
+			return nil, fmt.Errorf("unknown type '%s'", arg)
+		}
+		return func(actual any) (bool, string) {
+			if got := jsonType(actual); got != arg && !(arg == "number" && got == "integer") {
+				return false, fmt.Sprintf("expected type %s, got %s", arg, got)
+			}
+			return true, ""
+		}, nil
+	})
+
+	// --- Numbers ---
+	registerMatcher("gt", numberMatcher(">", func(a, b float64) bool { return a > b }))
+	registerMatcher("gte", numberMatcher(">=", func(a, b float64) bool { return a >= b }))
+	registerMatcher("lt", numberMatcher("<", func(a, b float64) bool { return a < b }))
+	registerMatcher("lte", numberMatcher("<=", func(a, b float64) bool { return a <= b }))
+	registerMatcher("approx", func(arg string) (matcherFunc, error) {
+		// "approx:3.14,0.01" or "approx:3.14" (uses the suite's float_tolerance)
+		value, tol, hasTol := strings.Cut(arg, ",")
+		want, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", value)
+		}
+		var tolerance float64
+		if hasTol {
+			if tolerance, err = strconv.ParseFloat(strings.TrimSpace(tol), 64); err != nil || tolerance < 0 {
+				return nil, fmt.Errorf("invalid tolerance '%s'", tol)
+			}
+		}
+		return func(actual any) (bool, string) {
+			n, ok := toFloat(actual)
+			if !ok {
+				return false, fmt.Sprintf("expected number, got %s", jsonType(actual))
+			}
+			limit := tolerance
+			if !hasTol {
+				limit = floatTolerance
+			}
+			if math.Abs(n-want) > limit {
+				return false, fmt.Sprintf("%v is not within %v of %v", n, limit, want)
+			}
+			return true, ""
+		}, nil
+	})
+	registerMatcher("between", func(arg string) (matcherFunc, error) {
+		lo, hi, found := strings.Cut(arg, ",")
+		if !found {
+			return nil, fmt.Errorf("expected 'between:min,max'")
+		}
+		low, errLow := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+		high, errHigh := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+		if errLow != nil || errHigh != nil {
+			return nil, fmt.Errorf("invalid range '%s'", arg)
+		}
+		return func(actual any) (bool, string) {
+			n, ok := toFloat(actual)
+			if !ok {
+				return false, fmt.Sprintf("expected number, got %s", jsonType(actual))
+			}
+			if n < low || n > high {
+				return false, fmt.Sprintf("%v is not between %v and %v", n, low, high)
+			}
+			return true, ""
+		}, nil
+	})
+
+	// --- Lengths (strings, arrays and objects) ---
+	registerMatcher("len", lengthMatcher("==", func(l, n int) bool { return l == n }))
+	registerMatcher("minlen", lengthMatcher(">=", func(l, n int) bool { return l >= n }))
+	registerMatcher("maxlen", lengthMatcher("<=", func(l, n int) bool { return l <= n }))
+}
+
+// stringMatcher wraps a check that only applies to JSON strings.
+func stringMatcher(check func(s string) (bool, string)) matcherFunc {
+	return func(actual any) (bool, string) {
+		s, ok := actual.(string)
+		if !ok {
+			return false, fmt.Sprintf("expected string, got %s", jsonType(actual))
+		}
+		return check(s)
+	}
+}
+
+// numberMatcher builds a comparison matcher such as "gt:0".
+func numberMatcher(op string, cmp func(a, b float64) bool) matcherFactory {
+	return func(arg string) (matcherFunc, error) {
+		want, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", arg)
+		}
+		return func(actual any) (bool, string) {
+			n, ok := toFloat(actual)
+			if !ok {
+				return false, fmt.Sprintf("expected number, got %s", jsonType(actual))
+			}
+			if !cmp(n, want) {
+				return false, fmt.Sprintf("%v is not %s %v", n, op, want)
+			}
+			return true, ""
+		}, nil
+	}
+}
+
+// lengthMatcher builds a length matcher such as "len:5". Strings are measured in characters.
+func lengthMatcher(op string, cmp func(l, n int) bool) matcherFactory {
+	return func(arg string) (matcherFunc, error) {
+		want, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil {
+			return nil, fmt.Errorf("invalid length '%s'", arg)
+		}
+		return func(actual any) (bool, string) {
+			var l int
+			switch act := actual.(type) {
+			case string:
+				l = utf8.RuneCountInString(act)
+			case []any:
+				l = len(act)
+			case map[string]any:
+				l = len(act)
+			default:
+				return false, fmt.Sprintf("expected string, array or object, got %s", jsonType(actual))
+			}
+			if !cmp(l, want) {
+				return false, fmt.Sprintf("length %d is not %s %d", l, op, want)
+			}
+			return true, ""
+		}, nil
+	}
+}
+
+// toFloat converts a decoded JSON number to float64.
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// jsonType returns the JSON type name of a decoded value. Whole numbers report "integer".
+func jsonType(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		if !isNumber(val) {
+			return fmt.Sprintf("%T", v)
+		}
+		if isWholeNumber(val) {
+			return "integer"
+		}
+		return "number"
+	}
+}
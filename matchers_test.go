@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupMatcher(t *testing.T) {
+	tests := []struct {
+		expected string
+		actual   string // JSON
+		want     bool
+	}{
+		// Keywords
+		{"any", `null`, true},
+		{"any", `{"a": 1}`, true},
+		{"uuid", `"123e4567-e89b-12d3-a456-426614174000"`, true},
+		{"uuid", `"123e4567-e89b-12d3-a456"`, false},
+		{"uuid", `1`, false},
+		{"email", `"ada@example.com"`, true},
+		{"email", `"Ada <ada@example.com>"`, false},
+		{"iso8601", `"2024-01-02T10:00:00Z"`, true},
+		{"iso8601", `"2024-01-02T10:00:00.123+02:00"`, true},
+		{"iso8601", `"2024-01-02"`, true},
+		{"iso8601", `"02/01/2024"`, false},
+		{"$null", `null`, true},
+		{"$null", `0`, false},
+		{"$absent", `1`, false},
+
+		// name:arg
+		{"type:string", `"a"`, true},
+		{"type:number", `1.5`, true},
+		{"type:number", `2`, true},
+		{"type:integer", `2`, true},
+		{"type:integer", `2.5`, false},
+		{"type:integer", `12345678901234567890`, true},
+		{"type:integer", `-98765432109876543210`, true},
+		{"type:number", `12345678901234567890.5`, true},
+		{"type:integer", `12345678901234567890.5`, false},
+		{"type:boolean", `false`, true},
+		{"type:object", `{}`, true},
+		{"type:array", `[]`, true},
+		{"type:null", `null`, true},
+		{"type:string", `null`, false},
+		{"gt:0", `1`, true},
+		{"gt:0", `0`, false},
+		{"gte:0", `0`, true},
+		{"lt:10", `9.99`, true},
+		{"lte:10", `10.01`, false},
+		{"gt:0", `"1"`, false},
+		{"between:1,100", `1`, true},
+		{"between:1,100", `100`, true},
+		{"between:1, 100", `100.5`, false},
+		{"approx:3.14,0.01", `3.145`, true},
+		{"approx:3.14,0.01", `3.16`, false},
+		{"approx:3.14", `3.14`, true},
+		{"len:3", `"héé"`, true},
+		{"len:2", `[1, 2]`, true},
+		{"len:1", `{"a": 1}`, true},
+		{"minlen:1", `""`, false},
+		{"maxlen:2", `[1, 2, 3]`, false},
+		{"len:1", `1`, false},
+		{"contains:foo", `"a food"`, true},
+		{"contains:2", `[1, 2]`, true},
+		{"contains:3", `[1, 2]`, false},
+		{"contains:a", `1`, false},
+		{"oneof:a|b|c", `"b"`, true},
+		{"oneof:a|b|c", `"d"`, false},
+		{"oneof:1|2", `2`, true},
+		{"regex:^user_\\d+$", `"user_12"`, true},
+		{"regex:^user_\\d+$", `"admin"`, false},
+		{"not:5", `5`, false},
+		{"not:5", `6`, true},
+		{"not:null", `null`, false},
+		{"not-regex:^tmp", `"tmp_1"`, false},
+		{"not-regex:^tmp", `"final"`, true},
+
+		// eq: compares the rest literally, even when it has the form of a matcher
+		{"eq:any", `"any"`, true},
+		{"eq:any", `"other"`, false},
+		{"eq:type:admin", `"type:admin"`, true},
+		{"eq:$null", `null`, false},
+		{"eq:", `""`, true},
+		{"eq:1", `1`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected+" "+tt.actual, func(t *testing.T) {
+			match, found, err := lookupMatcher(tt.expected)
+			if !found || err != nil {
+				t.Fatalf("lookupMatcher: found %v, err %v", found, err)
+			}
+			ok, reason := match(mustDecode(t, tt.actual))
+			if ok != tt.want {
+				t.Errorf("got %v (%s), want %v", ok, reason, tt.want)
+			}
+			if !ok && reason == "" {
+				t.Error("a failure must have a reason")
+			}
+		})
+	}
+}
+
+func TestLookupMatcherLiterals(t *testing.T) {
+	// Strings that are neither a keyword nor a registered name:arg are compared literally
+	for _, exp := range []string{"", "Any", "anything", "uuid ", "http://example.com", "note: hello", "$any", "$mode:ordered"} {
+		if _, found, err := lookupMatcher(exp); found || err != nil {
+			t.Errorf("%q: got found %v, err %v, want a literal", exp, found, err)
+		}
+	}
+}
+
+func TestLookupMatcherInvalid(t *testing.T) {
+	tests := []struct {
+		expected string
+		err      string
+	}{
+		{"type:decimal", "unknown type 'decimal'"},
+		{"gt:zero", "invalid number 'zero'"},
+		{"between:1", "expected 'between:min,max'"},
+		{"between:a,b", "invalid range 'a,b'"},
+		{"approx:x", "invalid number 'x'"},
+		{"approx:1,-1", "invalid tolerance '-1'"},
+		{"len:many", "invalid length 'many'"},
+		{"regex:(", "missing closing )"},
+		{"not-regex:[", "missing closing ]"},
+	}
+	for _, tt := range tests {
+		// The second lookup comes from the cache and must keep the error
+		for range 2 {
+			if _, found, err := lookupMatcher(tt.expected); !found || err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got found %v, err %v, want %q", tt.expected, found, err, tt.err)
+			}
+		}
+	}
+}
+
+func TestRegisterMatcher(t *testing.T) {
+	defer delete(prefixMatchers, "suffix")
+	defer delete(namedMatchers, "even")
+	registerMatcher("suffix", func(arg string) (matcherFunc, error) {
+		return stringMatcher(func(s string) (bool, string) {
+			return strings.HasSuffix(s, arg), "wrong suffix"
+		}), nil
+	})
+	registerNamedMatcher("even", func(actual any) (bool, string) {
+		n, ok := toFloat(actual)
+		return ok && int(n)%2 == 0, "not even"
+	})
+
+	tests := []struct {
+		expected any
+		actual   string
+		want     bool
+	}{
+		{"suffix:.png", `"logo.png"`, true},
+		{"suffix:.png", `"logo.gif"`, false},
+		{"even", `4`, true},
+		{"even", `3`, false},
+		{"eq:even", `"even"`, true},
+		{mustDecode(t, `{"file": "suffix:.png", "size": "even"}`), `{"file": "a.png", "size": 2}`, true},
+	}
+	for _, tt := range tests {
+		if got := validateBody(tt.expected, mustDecode(t, tt.actual), "", nil, matchMode{}); got != tt.want {
+			t.Errorf("%v against %s: got %v, want %v", tt.expected, tt.actual, got, tt.want)
+		}
+	}
+}
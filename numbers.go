@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// floatTolerance is the suite wide float_tolerance: non-integer numbers in expected_response
+// match when they differ by at most this much. Zero means exact comparison.
+var floatTolerance float64
+
+// decodeJSON decodes a JSON document keeping numbers as json.Number,
+// so large integers (IDs, amounts in cents) do not lose precision as float64.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	// Like json.Unmarshal, reject trailing data (e.g. "404 page not found" is not the number 404)
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+	return v, nil
+}
+
+// isNumber reports whether v is a decoded JSON number.
+func isNumber(v any) bool {
+	switch v.(type) {
+	case json.Number, float64, int:
+		return true
+	default:
+		return false
+	}
+}
+
+// isIntegerLiteral reports whether a number has no fraction or exponent (e.g. "42" but not "42.0").
+func isIntegerLiteral(v any) bool {
+	n, ok := v.(json.Number)
+	return ok && !strings.ContainsAny(string(n), ".eE")
+}
+
+// isWholeNumber reports whether a decoded JSON number has no fractional part (e.g. 42, 1.0 or 1e3).
+// json.Number literals are checked exactly, so integers beyond the range of int64 and float64 count too.
+func isWholeNumber(v any) bool {
+	switch n := v.(type) {
+	case int:
+		return true
+	case float64:
+		return !math.IsInf(n, 0) && n == math.Trunc(n)
+	case json.Number:
+		if isIntegerLiteral(n) {
+			_, ok := new(big.Int).SetString(string(n), 10)
+			return ok
+		}
+		// Enough bits for every digit of the literal, so 1.0000000000000000000001 is not rounded to 1
+		f, _, err := big.ParseFloat(string(n), 10, uint(len(n))*4+64, big.ToNearestEven)
+		return err == nil && !f.IsInf() && f.IsInt()
+	default:
+		return false
+	}
+}
+
+// numbersEqual compares two decoded JSON numbers of any representation (json.Number, float64, int).
+// Integers are compared exactly, however large. Other numbers are compared as float64,
+// within tolerance.
+func numbersEqual(expected, actual any, tolerance float64) bool {
+	if isIntegerLiteral(expected) && isIntegerLiteral(actual) {
+		a, okA := new(big.Int).SetString(string(expected.(json.Number)), 10)
+		b, okB := new(big.Int).SetString(string(actual.(json.Number)), 10)
+		if okA && okB {
+			return a.Cmp(b) == 0
+		}
+	}
+	a, okA := toFloat(expected)
+	b, okB := toFloat(actual)
+	if !okA || !okB {
+		return false
+	}
+	return math.Abs(a-b) <= tolerance
+}
+
+// jsonEqual compares two decoded JSON values, treating numbers by value rather than
+// by representation (so json.Number("1.0") equals float64(1)).
+func jsonEqual(a, b any) bool {
+	if isNumber(a) && isNumber(b) {
+		return numbersEqual(a, b, 0)
+	}
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, exists := y[k]
+			if !exists || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
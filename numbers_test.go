@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  any
+		err   bool
+	}{
+		{`12345678901234567890`, json.Number("12345678901234567890"), false},
+		{`1.50`, json.Number("1.50"), false},
+		{` {"id": 9007199254740993} `, map[string]any{"id": json.Number("9007199254740993")}, false},
+		{`[1e3, -0]`, []any{json.Number("1e3"), json.Number("-0")}, false},
+		{`"404"`, "404", false},
+		{`null`, nil, false},
+		{`404 page not found`, nil, true},
+		{`{"a": 1} {"b": 2}`, nil, true},
+		{`{"a": 1`, nil, true},
+		{``, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := decodeJSON([]byte(tt.input))
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v, want error %v", tt.input, err, tt.err)
+			continue
+		}
+		if !tt.err && !jsonEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.input, got, tt.want)
+		}
+		if n, ok := tt.want.(json.Number); ok && got != n {
+			t.Errorf("%q: the literal must be kept, got %#v", tt.input, got)
+		}
+	}
+}
+
+func TestIsWholeNumber(t *testing.T) {
+	tests := []struct {
+		value any
+		want  bool
+		typ   string
+	}{
+		{json.Number("42"), true, "integer"},
+		{json.Number("-7"), true, "integer"},
+		{json.Number("12345678901234567890"), true, "integer"},
+		{json.Number("-123456789012345678901234567890"), true, "integer"},
+		{json.Number("1.0"), true, "integer"},
+		{json.Number("1e3"), true, "integer"},
+		{json.Number("1.5e1"), true, "integer"},
+		{json.Number("1.5"), false, "number"},
+		{json.Number("1e-3"), false, "number"},
+		{json.Number("12345678901234567890.5"), false, "number"},
+		{json.Number("1.0000000000000000000001"), false, "number"},
+		{float64(3), true, "integer"},
+		{3.25, false, "number"},
+		{1e20, true, "integer"},
+		{7, true, "integer"},
+	}
+	for _, tt := range tests {
+		if got := isWholeNumber(tt.value); got != tt.want {
+			t.Errorf("isWholeNumber(%#v): got %v, want %v", tt.value, got, tt.want)
+		}
+		if got := jsonType(tt.value); got != tt.typ {
+			t.Errorf("jsonType(%#v): got %s, want %s", tt.value, got, tt.typ)
+		}
+	}
+}
+
+func TestNumbersEqual(t *testing.T) {
+	// Variables, so the sum is computed in float64 rather than as an exact constant
+	tenth, fifth := 0.1, 0.2
+	tests := []struct {
+		expected  any
+		actual    any
+		tolerance float64
+		want      bool
+	}{
+		{json.Number("1"), json.Number("1"), 0, true},
+		{json.Number("1"), json.Number("1.0"), 0, true},
+		{json.Number("1"), float64(1), 0, true},
+		{json.Number("1"), 1, 0, true},
+		{json.Number("100"), json.Number("1e2"), 0, true},
+		{json.Number("1.50"), json.Number("1.5"), 0, true},
+		// Integers are exact, however large, and ignore the tolerance
+		{json.Number("9007199254740993"), json.Number("9007199254740992"), 0, false},
+		{json.Number("12345678901234567890"), json.Number("12345678901234567890"), 0, true},
+		{json.Number("12345678901234567890"), json.Number("12345678901234567891"), 0, false},
+		{json.Number("10"), json.Number("11"), 5, false},
+		// Other numbers are compared within the tolerance
+		{json.Number("0.3"), tenth + fifth, 0, false},
+		{json.Number("0.3"), tenth + fifth, 1e-9, true},
+		{json.Number("19.99"), json.Number("19.989"), 0.001, true},
+		{json.Number("19.99"), json.Number("19.98"), 0.001, false},
+		{json.Number("10"), json.Number("10.0004"), 0.001, true},
+		{json.Number("x"), json.Number("1"), 0, false},
+		{"1", json.Number("1"), 0, false},
+	}
+	for _, tt := range tests {
+		if got := numbersEqual(tt.expected, tt.actual, tt.tolerance); got != tt.want {
+			t.Errorf("numbersEqual(%#v, %#v, %v): got %v, want %v", tt.expected, tt.actual, tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+func TestJSONEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{`{"a": [1, 2.0, {"b": null}]}`, `{"a": [1.0, 2, {"b": null}]}`, true},
+		{`{"a": 1}`, `{"a": 1, "b": 2}`, false},
+		{`[1, 2]`, `[2, 1]`, false},
+		{`{"a": "1"}`, `{"a": 1}`, false},
+		{`12345678901234567890`, `12345678901234567891`, false},
+	}
+	for _, tt := range tests {
+		if got := jsonEqual(mustDecode(t, tt.a), mustDecode(t, tt.b)); got != tt.want {
+			t.Errorf("%s vs %s: got %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFloatTolerance(t *testing.T) {
+	defer func(previous float64) { floatTolerance = previous }(floatTolerance)
+
+	tests := []struct {
+		tolerance float64
+		expected  string
+		actual    string
+		want      bool
+	}{
+		{0, `{"price": 19.99}`, `{"price": 19.99}`, true},
+		{0, `{"price": 19.99}`, `{"price": 19.990001}`, false},
+		{0.001, `{"price": 19.99}`, `{"price": 19.990001}`, true},
+		{0.001, `{"price": 19.99}`, `{"price": 19.992}`, false},
+		{0.001, `{"avg": [1.5, 2.5]}`, `{"avg": [2.5004, 1.4996]}`, true},
+		{0.5, `{"count": 10}`, `{"count": 10.2}`, true},
+		// Integers on both sides are always exact
+		{0.5, `{"id": 10}`, `{"id": 11}`, false},
+		{0.001, `{"price": "approx:20"}`, `{"price": 19.9995}`, true},
+		{0.001, `{"price": "approx:20"}`, `{"price": 19.99}`, false},
+		// An explicit approx tolerance wins over float_tolerance
+		{0.001, `{"price": "approx:20,0.1"}`, `{"price": 19.95}`, true},
+		{0.5, `{"price": "approx:20,0.01"}`, `{"price": 19.95}`, false},
+		{0, `{"price": "approx:20"}`, `{"price": 20.0}`, true},
+		{0, `{"price": "approx:20"}`, `{"price": "20"}`, false},
+	}
+	for _, tt := range tests {
+		floatTolerance = tt.tolerance
+		if got := validateBody(mustDecode(t, tt.expected), mustDecode(t, tt.actual), "", nil, matchMode{}); got != tt.want {
+			t.Errorf("%s against %s with float_tolerance %v: got %v, want %v", tt.expected, tt.actual, tt.tolerance, got, tt.want)
+		}
+	}
+}
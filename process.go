@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // preProcess is the main func for pre processing of the datas.
@@ -28,6 +33,119 @@ func (t *test) preProcess(testNo int) bool {
 		return false
 	}
 
+	if t.ExpectedUrl != "" {
+		// Process Expected Final URL
+		if t.ExpectedUrl, ok = processString(t.ExpectedUrl); !ok {
+			LogMsg("[FAIL] %v. Failed to process expected_url.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	if t.Query != nil {
+		// Process Query Parameters, then encode them into the URL
+		if ok := processMap(t.Query); !ok {
+			LogMsg("[FAIL] %v. Failed to process query.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+		var err error
+		if t.Url, err = mergeQuery(t.Url, t.Query); err != nil {
+			LogMsg("[FAIL] %v. Invalid Url '%s': %v\n\n", testNo, t.Url, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	if t.Auth != nil {
+		// Process and check the auth options
+		if ok := t.Auth.process(); !ok {
+			LogMsg("[FAIL] %v. Failed to process auth.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+		if err := t.Auth.validate(); err != nil {
+			LogMsg("[FAIL] %v. Invalid auth: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	if t.Sign != nil {
+		// Process and check the signing options
+		if ok := t.Sign.process(); !ok {
+			LogMsg("[FAIL] %v. Failed to process sign.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+		if err := t.Sign.validate(); err != nil {
+			LogMsg("[FAIL] %v. Invalid sign: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	// Parse the match mode for the expected response
+	if t.mode, ok = parseMatchMode(t.MatchMode, matchMode{}); !ok {
+		LogMsg("[FAIL] %v. Invalid match_mode '%s'.\n\n", testNo, t.MatchMode)
+		LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+		return false
+	}
+
+	// Resolve the cookie jar
+	if t.jar, ok = parseCookieJar(t.CookieJar); !ok {
+		LogMsg("[FAIL] %v. Invalid cookie_jar %v: use true, false or a jar name.\n\n", testNo, t.CookieJar)
+		LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+		return false
+	}
+
+	// Parse the response time limit
+	if t.MaxDuration != "" {
+		d, err := time.ParseDuration(t.MaxDuration)
+		if err != nil {
+			LogMsg("[FAIL] %v. Invalid max_duration '%s': %v\n\n", testNo, t.MaxDuration, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+		t.maxDuration = d
+	}
+
+	if t.ExpectedHeaders != nil {
+		// Process Expected Headers
+		if ok := processMap(t.ExpectedHeaders); !ok {
+			LogMsg("[FAIL] %v. Failed to process expected_headers.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	if t.ExpectedCookies != nil {
+		// Process Expected Cookies
+		if ok := processMap(t.ExpectedCookies); !ok {
+			LogMsg("[FAIL] %v. Failed to process expected_cookies.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	if t.ExpectedXML != nil {
+		// Process Expected XML (XPath keys)
+		if ok := processMap(t.ExpectedXML); !ok {
+			LogMsg("[FAIL] %v. Failed to process expected_xml.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	if t.ExpectedHTML != nil {
+		// Process Expected HTML (CSS selector keys)
+		if ok := processMap(t.ExpectedHTML); !ok {
+			LogMsg("[FAIL] %v. Failed to process expected_html.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
 	if t.ExpectedResponse != nil {
 		// Process Expected Response
 		if ok := processBody(t.ExpectedResponse); !ok {
@@ -37,7 +155,54 @@ func (t *test) preProcess(testNo int) bool {
 		}
 	}
 
-	if t.Body != nil {
+	if t.GraphQL != nil {
+		// Load the GraphQL query and process its variables. The query itself is not substituted,
+		// its own variables are written $name
+		if err := t.GraphQL.loadQuery(filepath.Dir(*path)); err != nil {
+			LogMsg("[FAIL] %v. Failed to read the graphql query: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+		if ok := processMap(t.GraphQL.Variables); !ok {
+			LogMsg("[FAIL] %v. Failed to process graphql variables.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+		if t.Method == "" {
+			t.Method = http.MethodPost
+		}
+	}
+
+	if t.WebSocket != nil {
+		// Check the WebSocket steps. Their messages are substituted when each step runs,
+		// so a step can use the values stored by the previous ones
+		if err := t.WebSocket.parseTimeouts(); err != nil {
+			LogMsg("[FAIL] %v. Invalid websocket: %v\n\n", testNo, err)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+		if t.Method == "" {
+			t.Method = http.MethodGet
+		}
+	}
+
+	if t.BodyRaw != "" {
+		// Process Raw Body (body_file is sent without substitution)
+		if t.BodyRaw, ok = processString(t.BodyRaw); !ok {
+			LogMsg("[FAIL] %v. Failed to process body_raw.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	}
+
+	if s, isString := t.Body.(string); isString {
+		// Process a raw string body
+		if t.Body, ok = processString(s); !ok {
+			LogMsg("[FAIL] %v. Failed to process Body.\n\n", testNo)
+			LogMsg("------------- Test %v Completed-------------\n\n", testNo)
+			return false
+		}
+	} else if t.Body != nil {
 		// Process Request Body
 		if ok := processBody(t.Body); !ok {
 			LogMsg("[FAIL] %v. Failed to process Body.\n\n", testNo)
@@ -64,9 +229,79 @@ func processHeader(header map[string]string) bool {
 }
 
 // processUrl performs variable substitution on the request URL.
+// Substituted values are escaped for the part of the URL they land in:
+// 1. Scheme and host (e.g., $base_url$ at the start): inserted as-is.
+// 2. Path: escaped as a path segment ("a b/c" -> "a%20b%2Fc").
+// 3. Query and fragment: escaped as a query value ("a&b" -> "a%26b").
+// The part is decided from the template, so a '/' or '?' inside an earlier value
+// (e.g. a $base_url$ of "http://host/api?v=2") does not change how later values are escaped.
 // Returns the processed URL and a boolean indicating success.
-func processUrl(url string) (string, bool) {
-	return processString(url)
+func processUrl(rawUrl string) (string, bool) {
+	var result strings.Builder
+	rest := rawUrl
+	for {
+		start := strings.IndexByte(rest, '$')
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(rest[start+1:], '$')
+		if end == -1 {
+			// An unclosed '$' is kept as-is, like in processString
+			break
+		}
+		result.WriteString(rest[:start])
+		value, ok := variableString(rest[start+1 : start+1+end])
+		if !ok {
+			return "", false
+		}
+		result.WriteString(escapeUrlValue(rawUrl[:len(rawUrl)-len(rest)+start], value))
+		rest = rest[start+end+2:]
+	}
+	result.WriteString(rest)
+	return result.String(), true
+}
+
+// escapeUrlValue escapes a substituted value according to the template text before it (prefix).
+func escapeUrlValue(prefix, value string) string {
+	if strings.ContainsAny(prefix, "?#") {
+		return url.QueryEscape(value)
+	}
+	// The path starts at the first '/' after the template's authority,
+	// or right after a leading variable such as $base_url$ when the template has no scheme
+	if _, afterScheme, found := strings.Cut(prefix, "://"); found {
+		if strings.Contains(afterScheme, "/") {
+			return url.PathEscape(value)
+		}
+		return value
+	}
+	if strings.HasPrefix(prefix, "$") {
+		if end := strings.IndexByte(prefix[1:], '$'); end != -1 {
+			if prefix[end+2:] != "" {
+				return url.PathEscape(value)
+			}
+			return value
+		}
+	}
+	if strings.Contains(prefix, "/") {
+		return url.PathEscape(value)
+	}
+	return value
+}
+
+// mergeQuery adds the query map of a test to the query of rawUrl, encoding the values.
+// A parameter in the map replaces the one of the same name already in the URL.
+// Array values repeat the parameter (e.g., "ids": [1, 2] -> ids=1&ids=2).
+func mergeQuery(rawUrl string, query map[string]any) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	values := u.Query()
+	for name, value := range query {
+		values[name] = formValues(value)
+	}
+	u.RawQuery = values.Encode()
+	return u.String(), nil
 }
 
 // processBody determines the underlying type of the body (map or slice)
@@ -159,30 +394,53 @@ func processString(str string) (string, bool) {
 			// end of variable declaration, perform lookup
 			firstPassed = false
 			// result += variables[varName]
-			t, ok := variables[varName]
+			replacement, ok := variableString(varName)
 			if !ok {
-				LogMsg("%v is not present in variables.\n", varName)
 				return "", false
 			}
-			// Handle different types (JSON numbers are float64 by default)
-			var replacement string
-			switch v := t.(type) {
-			case string:
-				replacement = v
-			case float64:
-				// FormatFloat with -1 removes trailing zeros (e.g., 123.0 -> "123")
-				replacement = strconv.FormatFloat(v, 'f', -1, 64)
-			case int:
-				replacement = strconv.Itoa(v)
-			case bool:
-				replacement = strconv.FormatBool(v)
-			default:
-				// Fallback for objects/arrays or other types
-				replacement = fmt.Sprintf("%v", v)
-			}
 			result += replacement
 			varName = ""
 		}
 	}
+	// An unclosed '$' is not a variable (e.g., "$mode:strict" markers or a trailing "$" in a regex), keep it as-is
+	if firstPassed {
+		result += "$" + varName
+	}
 	return result, true
 }
+
+// variableString looks up a variable and formats its value for substitution into a string.
+func variableString(varName string) (string, bool) {
+	// $jwt(ALG, key_file, claims_variable)$ mints a signed token
+	if strings.HasPrefix(varName, "jwt(") && strings.HasSuffix(varName, ")") {
+		token, err := mintJWT(varName[len("jwt(") : len(varName)-1])
+		if err != nil {
+			LogMsg("Cannot create the JWT '%s': %v\n", varName, err)
+			return "", false
+		}
+		return token, true
+	}
+	t, ok := variables[varName]
+	if !ok {
+		LogMsg("%v is not present in variables.\n", varName)
+		return "", false
+	}
+	// Handle different types (JSON numbers are json.Number, or float64 from older decoders)
+	switch v := t.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		// Keeps the exact digits of large integers
+		return v.String(), true
+	case float64:
+		// FormatFloat with -1 removes trailing zeros (e.g., 123.0 -> "123")
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		// Fallback for objects/arrays or other types
+		return fmt.Sprintf("%v", v), true
+	}
+}
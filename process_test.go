@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// useVariables adds vars to the stored variables, removing them again on restore.
+func useVariables(vars map[string]any) (restore func()) {
+	for name, value := range vars {
+		variables[name] = value
+	}
+	return func() {
+		for name := range vars {
+			delete(variables, name)
+		}
+	}
+}
+
+func TestProcessUrl(t *testing.T) {
+	defer useTest(&test{})()
+	defer useVariables(map[string]any{
+		"base_url":  "http://localhost:8080",
+		"api_url":   "http://localhost:8080/api?v=2",
+		"host":      "localhost:8080",
+		"scheme":    "https",
+		"id":        json.Number("42"),
+		"name":      "a b/c",
+		"term":      "a&b=c d",
+		"section":   "#top",
+		"empty":     "",
+		"port_path": "8080/x",
+	})()
+
+	tests := []struct {
+		rawUrl string
+		want   string
+		ok     bool
+	}{
+		{"$base_url$/users/$id$", "http://localhost:8080/users/42", true},
+		{"$base_url$/users/$name$", "http://localhost:8080/users/a%20b%2Fc", true},
+		{"$base_url$/search?q=$term$", "http://localhost:8080/search?q=a%26b%3Dc+d", true},
+		{"$base_url$/page#$section$", "http://localhost:8080/page#%23top", true},
+		{"$scheme$://$host$/users/$name$", "https://localhost:8080/users/a%20b%2Fc", true},
+		{"http://$host$/$name$", "http://localhost:8080/a%20b%2Fc", true},
+		// A value in the authority is inserted as-is, even with a '/' in it
+		{"http://localhost:$port_path$/$id$", "http://localhost:8080/x/42", true},
+		// The '/' and '?' of an earlier value do not make later values path or query values
+		{"$api_url$&name=$name$", "http://localhost:8080/api?v=2&name=a%20b%2Fc", true},
+		{"$api_url$$id$", "http://localhost:8080/api?v=242", true},
+		{"$base_url$$name$", "http://localhost:8080a b/c", true},
+		{"$base_url$:$id$/users", "http://localhost:8080:42/users", true},
+		{"localhost:8080/users/$name$", "localhost:8080/users/a%20b%2Fc", true},
+		{"$base_url$/users/$empty$", "http://localhost:8080/users/", true},
+		{"http://localhost/users?price=$5", "http://localhost/users?price=$5", true},
+		{"http://localhost/a$b", "http://localhost/a$b", true},
+		{"$base_url$/users/$missing$", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := processUrl(tt.rawUrl)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.rawUrl, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMergeQuery(t *testing.T) {
+	tests := []struct {
+		rawUrl string
+		query  string
+		want   string
+	}{
+		{"http://localhost/users", `{"page": 2, "q": "a b&c"}`, "http://localhost/users?page=2&q=a+b%26c"},
+		{"http://localhost/users?page=1&sort=name", `{"page": 3}`, "http://localhost/users?page=3&sort=name"},
+		{"http://localhost/users", `{"ids": [1, 2, 3]}`, "http://localhost/users?ids=1&ids=2&ids=3"},
+		{"http://localhost/users?ids=9", `{"ids": ["a", "b"]}`, "http://localhost/users?ids=a&ids=b"},
+		{"http://localhost/users", `{"active": true, "deleted": null, "price": 19.90}`, "http://localhost/users?active=true&deleted=null&price=19.9"},
+		{"http://localhost/users", `{"big": 12345678901234567890}`, "http://localhost/users?big=12345678901234567890"},
+		{"http://localhost/users#top", `{"z": 1, "a": 2}`, "http://localhost/users?a=2&z=1#top"},
+		{"http://localhost/users?x=1", `{}`, "http://localhost/users?x=1"},
+	}
+	for _, tt := range tests {
+		got, err := mergeQuery(tt.rawUrl, mustDecode(t, tt.query).(map[string]any))
+		if err != nil || got != tt.want {
+			t.Errorf("%s with %s: got %q, %v, want %q", tt.rawUrl, tt.query, got, err, tt.want)
+		}
+	}
+	if _, err := mergeQuery("http://local host/%zz", map[string]any{"a": 1}); err == nil {
+		t.Error("an invalid URL must fail")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// defaultMaxRedirects is the number of redirects followed when max_redirects is not set.
+// net/http stops after 10 requests, that is 9 redirects; this allows 10 redirects, so 11 requests.
+const defaultMaxRedirects = 10
+
+// redirectHop is one redirect followed by a test, shown in the report.
+type redirectHop struct {
+	Status   int    `json:"status"`
+	Url      string `json:"url"`
+	Location string `json:"location"`
+}
+
+// checkRedirect is the http.Client CheckRedirect of a test. It records the redirect chain and
+// enforces follow_redirects and max_redirects.
+func (t *test) checkRedirect(req *http.Request, via []*http.Request) error {
+	if t.FollowRedirects != nil && !*t.FollowRedirects {
+		// Return the 3xx response itself so its status and Location can be asserted
+		return http.ErrUseLastResponse
+	}
+
+	t.Redirects = append(t.Redirects, redirectHop{
+		Status:   req.Response.StatusCode,
+		Url:      via[len(via)-1].URL.String(),
+		Location: req.URL.String(),
+	})
+	LogMsg("[NOTE] Redirect %d: %d %s -> %s\n", len(via), req.Response.StatusCode, via[len(via)-1].URL, req.URL)
+
+	limit := t.MaxRedirects
+	if limit == 0 {
+		limit = defaultMaxRedirects
+	}
+	if len(via) > limit {
+		return fmt.Errorf("more than %d redirects (max_redirects)", limit)
+	}
+	return nil
+}
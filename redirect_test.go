@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newRedirectServer is a server where /hop/N redirects to /hop/N-1 with a 302, and /hop/0 answers "done".
+func newRedirectServer(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n == 0 {
+			w.Write([]byte("done"))
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+	}))
+	tb.Cleanup(server.Close)
+	return server
+}
+
+// getFollowing requests url with the redirect policy of tc, as the runner does.
+func getFollowing(tb testing.TB, tc *test, url string) (*http.Response, error) {
+	tb.Helper()
+	client := &http.Client{CheckRedirect: tc.checkRedirect}
+	res, err := client.Get(url)
+	if err == nil {
+		res.Body.Close()
+	}
+	return res, err
+}
+
+func TestCheckRedirect(t *testing.T) {
+	server := newRedirectServer(t)
+	no := false
+
+	tests := []struct {
+		name   string
+		tc     *test
+		hops   int
+		status int
+		err    bool
+		chain  int
+	}{
+		{"followed by default", &test{}, 3, http.StatusOK, false, 3},
+		{"default limit", &test{}, defaultMaxRedirects, http.StatusOK, false, defaultMaxRedirects},
+		{"above the default limit", &test{}, defaultMaxRedirects + 1, 0, true, defaultMaxRedirects + 1},
+		{"max_redirects", &test{MaxRedirects: 2}, 2, http.StatusOK, false, 2},
+		{"above max_redirects", &test{MaxRedirects: 2}, 3, 0, true, 3},
+		{"max_redirects above the default", &test{MaxRedirects: 15}, 12, http.StatusOK, false, 12},
+		{"not followed", &test{FollowRedirects: &no}, 3, http.StatusFound, false, 0},
+	}
+	for _, tt := range tests {
+		restore := useTest(tt.tc)
+		res, err := getFollowing(t, tt.tc, fmt.Sprintf("%s/hop/%d", server.URL, tt.hops))
+		restore()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil && !strings.Contains(err.Error(), "max_redirects") {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if err == nil && res.StatusCode != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.status)
+		}
+		if len(tt.tc.Redirects) != tt.chain {
+			t.Errorf("%s: got %d redirects in the chain, want %d", tt.name, len(tt.tc.Redirects), tt.chain)
+		}
+	}
+}
+
+func TestCheckRedirectChain(t *testing.T) {
+	server := newRedirectServer(t)
+	tc := &test{}
+	defer useTest(tc)()
+
+	res, err := getFollowing(t, tc, server.URL+"/hop/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Request.URL.Path; got != "/hop/0" {
+		t.Errorf("final URL: got %s", got)
+	}
+	want := []redirectHop{
+		{http.StatusFound, server.URL + "/hop/2", server.URL + "/hop/1"},
+		{http.StatusFound, server.URL + "/hop/1", server.URL + "/hop/0"},
+	}
+	if !reflect.DeepEqual(tc.Redirects, want) {
+		t.Errorf("got chain %+v, want %+v", tc.Redirects, want)
+	}
+
+	// Not following returns the 3xx itself, with its Location
+	no := false
+	tc = &test{FollowRedirects: &no}
+	defer useTest(tc)()
+	res, err = getFollowing(t, tc, server.URL+"/hop/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := res.Header.Get("Location"); res.StatusCode != http.StatusFound || loc != "/hop/0" {
+		t.Errorf("got %d with Location %q", res.StatusCode, loc)
+	}
+}
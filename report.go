@@ -61,6 +61,10 @@ func GenerateHTMLReport(data inputType, totalTime string, total int, passed int)
 					b, _ := json.MarshalIndent(js, "", "  ")
 					return string(b)
 				}
+				// XML and HTML bodies are re-indented
+				if pretty, ok := prettyMarkup(s); ok {
+					return pretty
+				}
 				return s
 			}
 			b, err := json.MarshalIndent(v, "", "  ")
@@ -69,8 +73,8 @@ func GenerateHTMLReport(data inputType, totalTime string, total int, passed int)
 			}
 			return string(b)
 		},
-		"statusColor": func(actual, expected string) string {
-			if strings.TrimSpace(actual) == strings.TrimSpace(expected) {
+		"statusColor": func(actual int, expected any) string {
+			if matchStatus(expected, actual) {
 				return "bg-green-100 text-green-800 border-green-200"
 			}
 			return "bg-red-100 text-red-800 border-red-200"
@@ -89,8 +93,21 @@ func GenerateHTMLReport(data inputType, totalTime string, total int, passed int)
 				return "bg-gray-100 text-gray-800"
 			}
 		},
-		"isPass": func(actual, expected string) bool {
-			return strings.TrimSpace(actual) == strings.TrimSpace(expected)
+		"isPass": func(actual int, expected any) bool {
+			return matchStatus(expected, actual)
+		},
+		"expectedStatus": func(t test) any {
+			return t.expectedStatus()
+		},
+		"diffLineColor": func(line string) string {
+			switch {
+			case strings.HasPrefix(line, "- "):
+				return "text-red-300 bg-red-900/40"
+			case strings.HasPrefix(line, "+ "):
+				return "text-green-300 bg-green-900/40"
+			default:
+				return "text-slate-400"
+			}
 		},
 	}
 
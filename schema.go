@@ -0,0 +1,483 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// schemaDoc is a loaded schema document. $ref values are resolved against it:
+// "#/..." points inside root, anything else is a file path relative to dir.
+type schemaDoc struct {
+	root any
+	dir  string
+}
+
+// schemaFiles caches schema files loaded through $ref, keyed by absolute path.
+var schemaFiles = map[string]any{}
+
+// schemaValidator walks a JSON Schema (draft 2020-12) alongside a decoded JSON value
+// and collects every violation instead of stopping at the first one.
+// Supported keywords:
+// 1. Core: $ref (local "#/..." pointers and files), $defs, allOf, anyOf, oneOf, not, if/then/else
+// 2. Any type: type, enum, const
+// 3. Objects: properties, required, additionalProperties, patternProperties, propertyNames,
+// minProperties, maxProperties, dependentRequired
+// 4. Arrays: prefixItems, items, contains, minContains, maxContains, minItems, maxItems, uniqueItems
+// 5. Strings: minLength, maxLength, pattern, format (date-time, date, time, email, uuid, uri, ipv4, ipv6)
+// 6. Numbers: minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+type schemaValidator struct {
+	errors []string
+	depth  int
+}
+
+// maxSchemaDepth guards against $ref cycles that never consume any input.
+const maxSchemaDepth = 256
+
+// validateSchema validates instance against schema and returns every violation,
+// each prefixed with the JSON pointer of the offending value.
+// dir is the base directory for file references.
+func validateSchema(schema, instance any, dir string) []string {
+	v := &schemaValidator{}
+	doc := schemaDoc{root: schema, dir: dir}
+	v.validate(schema, doc, instance, "")
+	// Map iteration order is random, keep the report stable
+	sort.Strings(v.errors)
+	return v.errors
+}
+
+// fail records a violation at the given JSON pointer.
+func (v *schemaValidator) fail(ptr string, format string, args ...any) {
+	if ptr == "" {
+		ptr = "(root)"
+	}
+	v.errors = append(v.errors, ptr+": "+fmt.Sprintf(format, args...))
+}
+
+// check runs a sub-schema in isolation and reports whether it passed.
+// Used by anyOf/oneOf/not/if/contains where failures of a branch are not failures of the whole.
+func (v *schemaValidator) check(schema any, doc schemaDoc, instance any, ptr string) bool {
+	sub := &schemaValidator{depth: v.depth}
+	sub.validate(schema, doc, instance, ptr)
+	return len(sub.errors) == 0
+}
+
+func (v *schemaValidator) validate(schema any, doc schemaDoc, instance any, ptr string) {
+	v.depth++
+	defer func() { v.depth-- }()
+	if v.depth > maxSchemaDepth {
+		v.fail(ptr, "schema nesting too deep (recursive $ref?)")
+		return
+	}
+
+	// Boolean schemas: true accepts everything, false rejects everything
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.fail(ptr, "value is not allowed by schema 'false'")
+		}
+		return
+	case map[string]any:
+		v.validateObjectSchema(s, doc, instance, ptr)
+	default:
+		v.fail(ptr, "invalid schema of type %T", schema)
+	}
+}
+
+func (v *schemaValidator) validateObjectSchema(s map[string]any, doc schemaDoc, instance any, ptr string) {
+	// --- $ref (applied alongside sibling keywords, as in 2020-12) ---
+	if ref, ok := s["$ref"].(string); ok {
+		target, targetDoc, err := resolveSchemaRef(ref, doc)
+		if err != nil {
+			v.fail(ptr, "cannot resolve $ref '%s': %v", ref, err)
+		} else {
+			v.validate(target, targetDoc, instance, ptr)
+		}
+	}
+
+	// --- Generic keywords ---
+	if t, ok := s["type"]; ok {
+		if !schemaTypeMatches(t, instance) {
+			v.fail(ptr, "expected type %v, got %s", t, jsonType(instance))
+			// Type specific keywords would only produce noise
+			return
+		}
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, instance) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "value %v is not one of %v", scalarString(instance), enum)
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(c, instance) {
+		v.fail(ptr, "value %v is not equal to const %v", scalarString(instance), scalarString(c))
+	}
+
+	// --- Composition ---
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			v.validate(sub, doc, instance, ptr)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.check(sub, doc, instance, ptr) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(ptr, "value does not match any schema in anyOf")
+		}
+	}
+	if one, ok := s["oneOf"].([]any); ok {
+		count := 0
+		for _, sub := range one {
+			if v.check(sub, doc, instance, ptr) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(ptr, "value matches %d schemas in oneOf, expected exactly 1", count)
+		}
+	}
+	if not, ok := s["not"]; ok && v.check(not, doc, instance, ptr) {
+		v.fail(ptr, "value must not match the 'not' schema")
+	}
+	if cond, ok := s["if"]; ok {
+		if v.check(cond, doc, instance, ptr) {
+			if then, ok := s["then"]; ok {
+				v.validate(then, doc, instance, ptr)
+			}
+		} else if els, ok := s["else"]; ok {
+			v.validate(els, doc, instance, ptr)
+		}
+	}
+
+	// --- Type specific keywords ---
+	switch inst := instance.(type) {
+	case map[string]any:
+		v.validateObject(s, doc, inst, ptr)
+	case []any:
+		v.validateArray(s, doc, inst, ptr)
+	case string:
+		v.validateString(s, inst, ptr)
+	default:
+		if n, ok := toFloat(instance); ok {
+			v.validateNumber(s, n, ptr)
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(s map[string]any, doc schemaDoc, obj map[string]any, ptr string) {
+	if required, ok := s["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, exists := obj[name]; !exists {
+				v.fail(ptr, "missing required property '%s'", name)
+			}
+		}
+	}
+	if n, ok := toFloat(s["minProperties"]); ok && float64(len(obj)) < n {
+		v.fail(ptr, "has %d properties, minimum is %v", len(obj), n)
+	}
+	if n, ok := toFloat(s["maxProperties"]); ok && float64(len(obj)) > n {
+		v.fail(ptr, "has %d properties, maximum is %v", len(obj), n)
+	}
+	if deps, ok := s["dependentRequired"].(map[string]any); ok {
+		for name, list := range deps {
+			if _, exists := obj[name]; !exists {
+				continue
+			}
+			names, _ := list.([]any)
+			for _, d := range names {
+				dep, _ := d.(string)
+				if _, exists := obj[dep]; !exists {
+					v.fail(ptr, "property '%s' requires property '%s'", name, dep)
+				}
+			}
+		}
+	}
+
+	props, _ := s["properties"].(map[string]any)
+	patterns, _ := s["patternProperties"].(map[string]any)
+	additional, hasAdditional := s["additionalProperties"]
+	names, hasNames := s["propertyNames"]
+
+	for key, val := range obj {
+		childPtr := ptr + "/" + escapePointer(key)
+
+		if hasNames && !v.check(names, doc, key, childPtr) {
+			v.fail(childPtr, "property name '%s' does not match propertyNames", key)
+		}
+
+		evaluated := false
+		if sub, ok := props[key]; ok {
+			evaluated = true
+			v.validate(sub, doc, val, childPtr)
+		}
+		for pattern, sub := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				v.fail(ptr, "invalid patternProperties regex '%s': %v", pattern, err)
+				continue
+			}
+			if re.MatchString(key) {
+				evaluated = true
+				v.validate(sub, doc, val, childPtr)
+			}
+		}
+		if !evaluated && hasAdditional {
+			if b, ok := additional.(bool); ok && !b {
+				v.fail(childPtr, "additional property '%s' is not allowed", key)
+			} else {
+				v.validate(additional, doc, val, childPtr)
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(s map[string]any, doc schemaDoc, arr []any, ptr string) {
+	if n, ok := toFloat(s["minItems"]); ok && float64(len(arr)) < n {
+		v.fail(ptr, "has %d items, minimum is %v", len(arr), n)
+	}
+	if n, ok := toFloat(s["maxItems"]); ok && float64(len(arr)) > n {
+		v.fail(ptr, "has %d items, maximum is %v", len(arr), n)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		seen := map[string]int{}
+		for i, item := range arr {
+			// encoding/json sorts map keys, so equal values encode identically
+			b, _ := json.Marshal(item)
+			if j, dup := seen[string(b)]; dup {
+				v.fail(ptr, "items at index %d and %d are equal, uniqueItems is set", j, i)
+				continue
+			}
+			seen[string(b)] = i
+		}
+	}
+
+	prefix, _ := s["prefixItems"].([]any)
+	for i, sub := range prefix {
+		if i >= len(arr) {
+			break
+		}
+		v.validate(sub, doc, arr[i], ptr+"/"+strconv.Itoa(i))
+	}
+	if items, ok := s["items"]; ok {
+		for i := len(prefix); i < len(arr); i++ {
+			if b, isBool := items.(bool); isBool && !b {
+				v.fail(ptr+"/"+strconv.Itoa(i), "additional item is not allowed")
+				continue
+			}
+			v.validate(items, doc, arr[i], ptr+"/"+strconv.Itoa(i))
+		}
+	}
+
+	if contains, ok := s["contains"]; ok {
+		count := 0
+		for i, item := range arr {
+			if v.check(contains, doc, item, ptr+"/"+strconv.Itoa(i)) {
+				count++
+			}
+		}
+		minContains := 1.0
+		if n, ok := toFloat(s["minContains"]); ok {
+			minContains = n
+		}
+		if float64(count) < minContains {
+			v.fail(ptr, "%d items match 'contains', minimum is %v", count, minContains)
+		}
+		if n, ok := toFloat(s["maxContains"]); ok && float64(count) > n {
+			v.fail(ptr, "%d items match 'contains', maximum is %v", count, n)
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(s map[string]any, str string, ptr string) {
+	length := utf8.RuneCountInString(str)
+	if n, ok := toFloat(s["minLength"]); ok && float64(length) < n {
+		v.fail(ptr, "length %d is less than minLength %v", length, n)
+	}
+	if n, ok := toFloat(s["maxLength"]); ok && float64(length) > n {
+		v.fail(ptr, "length %d is greater than maxLength %v", length, n)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.fail(ptr, "invalid pattern '%s': %v", pattern, err)
+		} else if !re.MatchString(str) {
+			v.fail(ptr, "'%s' does not match pattern '%s'", str, pattern)
+		}
+	}
+	if format, ok := s["format"].(string); ok && !schemaFormatMatches(format, str) {
+		v.fail(ptr, "'%s' is not a valid %s", str, format)
+	}
+}
+
+func (v *schemaValidator) validateNumber(s map[string]any, n float64, ptr string) {
+	if min, ok := toFloat(s["minimum"]); ok && n < min {
+		v.fail(ptr, "%v is less than minimum %v", n, min)
+	}
+	if max, ok := toFloat(s["maximum"]); ok && n > max {
+		v.fail(ptr, "%v is greater than maximum %v", n, max)
+	}
+	if min, ok := toFloat(s["exclusiveMinimum"]); ok && n <= min {
+		v.fail(ptr, "%v is not greater than exclusiveMinimum %v", n, min)
+	}
+	if max, ok := toFloat(s["exclusiveMaximum"]); ok && n >= max {
+		v.fail(ptr, "%v is not less than exclusiveMaximum %v", n, max)
+	}
+	if m, ok := toFloat(s["multipleOf"]); ok && m > 0 {
+		if q := n / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(ptr, "%v is not a multiple of %v", n, m)
+		}
+	}
+}
+
+// schemaTypeMatches checks the "type" keyword, which is either a name or a list of names.
+func schemaTypeMatches(t any, instance any) bool {
+	got := jsonType(instance)
+	matches := func(name string) bool {
+		return name == got || (name == "number" && got == "integer")
+	}
+	switch tt := t.(type) {
+	case string:
+		return matches(tt)
+	case []any:
+		for _, item := range tt {
+			if name, ok := item.(string); ok && matches(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaFormatMatches checks the "format" keyword. Unknown formats are accepted, as the spec allows.
+func schemaFormatMatches(format, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	case "time":
+		_, err := time.Parse("15:04:05Z07:00", s)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	case "uuid":
+		ok, _ := namedMatchers["uuid"](s)
+		return ok
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	case "ipv4":
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil && strings.Contains(s, ".")
+	case "ipv6":
+		ip := net.ParseIP(s)
+		return ip != nil && strings.Contains(s, ":")
+	default:
+		return true
+	}
+}
+
+// resolveSchemaRef resolves a $ref against the current document.
+// "#/$defs/user" points into the current document, "user.json" or "common.json#/$defs/id"
+// load a file relative to the current document's directory.
+func resolveSchemaRef(ref string, doc schemaDoc) (any, schemaDoc, error) {
+	file, fragment, _ := strings.Cut(ref, "#")
+	target := doc
+	if file != "" {
+		p := file
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(doc.dir, p)
+		}
+		root, err := loadSchemaFile(p)
+		if err != nil {
+			return nil, doc, err
+		}
+		target = schemaDoc{root: root, dir: filepath.Dir(p)}
+	}
+	node, err := resolvePointer(target.root, fragment)
+	return node, target, err
+}
+
+// loadSchemaFile reads and decodes a schema file, caching the result.
+func loadSchemaFile(p string) (any, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := schemaFiles[abs]; ok {
+		return s, nil
+	}
+	content, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	s, err := decodeJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON in %s: %w", abs, err)
+	}
+	schemaFiles[abs] = s
+	return s, nil
+}
+
+// resolvePointer follows a JSON pointer (RFC 6901) such as "/$defs/user" inside doc.
+func resolvePointer(doc any, pointer string) (any, error) {
+	if pointer == "" {
+		return doc, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("unsupported fragment '%s' (only JSON pointers are supported)", pointer)
+	}
+	current := doc
+	for token := range strings.SplitSeq(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := current.(type) {
+		case map[string]any:
+			val, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("'%s' not found", token)
+			}
+			current = val
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("invalid index '%s'", token)
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %T at '%s'", current, token)
+		}
+	}
+	return current, nil
+}
+
+// escapePointer escapes a key for use in a JSON pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustDecode(tb testing.TB, s string) any {
+	tb.Helper()
+	v, err := decodeJSON([]byte(s))
+	if err != nil {
+		tb.Fatalf("invalid JSON %s: %v", s, err)
+	}
+	return v
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		instance string
+		// errors are substrings of the expected violations, none when the instance is valid
+		errors []string
+	}{
+		{"true schema", `true`, `{"a":1}`, nil},
+		{"false schema", `false`, `1`, []string{"(root): value is not allowed"}},
+		{"type", `{"type":"string"}`, `1`, []string{"expected type string, got integer"}},
+		{"integer is a number", `{"type":"number"}`, `3`, nil},
+		{"type list", `{"type":["string","null"]}`, `null`, nil},
+		{"integer rejects fraction", `{"type":"integer"}`, `1.5`, []string{"expected type integer"}},
+		{"integer beyond int64", `{"type":"integer"}`, `12345678901234567890`, nil},
+		{"integer with a zero fraction", `{"type":"integer"}`, `2.0`, nil},
+		{"enum", `{"enum":["a","b"]}`, `"c"`, []string{"is not one of"}},
+		{"enum by number value", `{"enum":[1,2]}`, `1.0`, nil},
+		{"const", `{"const":{"x":1}}`, `{"x":2}`, []string{"not equal to const"}},
+
+		{"required", `{"required":["id","name"]}`, `{"id":1}`, []string{"missing required property 'name'"}},
+		{"properties", `{"properties":{"id":{"type":"integer"}}}`, `{"id":"1"}`, []string{"/id: expected type integer"}},
+		{"additionalProperties false", `{"properties":{"id":{}},"additionalProperties":false}`, `{"id":1,"x":2}`, []string{"/x: additional property 'x'"}},
+		{"additionalProperties schema", `{"additionalProperties":{"type":"string"}}`, `{"a":"x","b":2}`, []string{"/b: expected type string"}},
+		{"patternProperties", `{"patternProperties":{"^n_":{"type":"number"}},"additionalProperties":false}`, `{"n_a":1,"n_b":"x"}`, []string{"/n_b: expected type number"}},
+		{"propertyNames", `{"propertyNames":{"maxLength":3}}`, `{"abcd":1}`, []string{"does not match propertyNames"}},
+		{"minProperties", `{"minProperties":2}`, `{"a":1}`, []string{"minimum is 2"}},
+		{"maxProperties", `{"maxProperties":1}`, `{"a":1,"b":2}`, []string{"maximum is 1"}},
+		{"dependentRequired", `{"dependentRequired":{"card":["cvv"]}}`, `{"card":"4111"}`, []string{"property 'card' requires property 'cvv'"}},
+		{"escaped pointer", `{"properties":{"a/b":{"type":"string"}}}`, `{"a/b":1}`, []string{"/a~1b: expected type string"}},
+
+		{"items", `{"items":{"type":"integer"}}`, `[1,"x",3]`, []string{"/1: expected type integer"}},
+		{"prefixItems", `{"prefixItems":[{"type":"string"}],"items":{"type":"integer"}}`, `["a",1,"b"]`, []string{"/2: expected type integer"}},
+		{"items false", `{"prefixItems":[{}],"items":false}`, `[1,2]`, []string{"/1: additional item is not allowed"}},
+		{"minItems", `{"minItems":2}`, `[1]`, []string{"minimum is 2"}},
+		{"maxItems", `{"maxItems":1}`, `[1,2]`, []string{"maximum is 1"}},
+		{"uniqueItems", `{"uniqueItems":true}`, `[{"a":1},{"a":1}]`, []string{"items at index 0 and 1 are equal"}},
+		{"contains", `{"contains":{"const":3}}`, `[1,2]`, []string{"0 items match 'contains', minimum is 1"}},
+		{"maxContains", `{"contains":{"type":"string"},"maxContains":1}`, `["a","b",1]`, []string{"maximum is 1"}},
+
+		{"minLength counts runes", `{"minLength":3}`, `"éé"`, []string{"length 2 is less than minLength 3"}},
+		{"maxLength", `{"maxLength":2}`, `"abc"`, []string{"greater than maxLength"}},
+		{"pattern", `{"pattern":"^[a-z]+$"}`, `"ab1"`, []string{"does not match pattern"}},
+		{"format email", `{"format":"email"}`, `"not-an-email"`, []string{"is not a valid email"}},
+		{"format uuid", `{"format":"uuid"}`, `"123e4567-e89b-12d3-a456-426614174000"`, nil},
+		{"format date-time", `{"format":"date-time"}`, `"2024-01-02"`, []string{"is not a valid date-time"}},
+		{"format ipv4", `{"format":"ipv4"}`, `"::1"`, []string{"is not a valid ipv4"}},
+
+		{"minimum", `{"minimum":1}`, `0`, []string{"less than minimum"}},
+		{"maximum", `{"maximum":10}`, `10`, nil},
+		{"exclusiveMinimum", `{"exclusiveMinimum":1}`, `1`, []string{"not greater than exclusiveMinimum"}},
+		{"exclusiveMaximum", `{"exclusiveMaximum":1}`, `1`, []string{"not less than exclusiveMaximum"}},
+		{"multipleOf decimal", `{"multipleOf":0.01}`, `19.99`, nil},
+		{"multipleOf", `{"multipleOf":5}`, `12`, []string{"not a multiple of 5"}},
+
+		{"allOf", `{"allOf":[{"minimum":1},{"maximum":3}]}`, `5`, []string{"greater than maximum"}},
+		{"anyOf", `{"anyOf":[{"type":"string"},{"type":"boolean"}]}`, `1`, []string{"does not match any schema in anyOf"}},
+		{"oneOf none", `{"oneOf":[{"type":"string"},{"minimum":5}]}`, `1`, []string{"matches 0 schemas in oneOf"}},
+		{"oneOf two", `{"oneOf":[{"type":"integer"},{"minimum":0}]}`, `1`, []string{"matches 2 schemas in oneOf"}},
+		{"not", `{"not":{"type":"null"}}`, `null`, []string{"must not match the 'not' schema"}},
+		{"if then", `{"if":{"properties":{"kind":{"const":"card"}}},"then":{"required":["last4"]}}`, `{"kind":"card"}`, []string{"missing required property 'last4'"}},
+		{"if else", `{"if":{"properties":{"kind":{"const":"card"}}},"then":{"required":["last4"]},"else":{"required":["iban"]}}`, `{"kind":"bank"}`, []string{"missing required property 'iban'"}},
+
+		{"local $ref", `{"$defs":{"id":{"type":"integer"}},"properties":{"id":{"$ref":"#/$defs/id"}}}`, `{"id":"x"}`, []string{"/id: expected type integer"}},
+		{"unresolved $ref", `{"$ref":"#/$defs/missing"}`, `1`, []string{"cannot resolve $ref"}},
+		{"recursive $ref", `{"$ref":"#"}`, `1`, []string{"schema nesting too deep"}},
+		{"every violation", `{"required":["a","b"],"properties":{"c":{"type":"string"}}}`, `{"c":1}`,
+			[]string{"missing required property 'a'", "missing required property 'b'", "/c: expected type string"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateSchema(mustDecode(t, tt.schema), mustDecode(t, tt.instance), "")
+			if len(errs) != len(tt.errors) {
+				t.Fatalf("got %d violations %q, want %d", len(errs), errs, len(tt.errors))
+			}
+			for _, want := range tt.errors {
+				found := false
+				for _, e := range errs {
+					if strings.Contains(e, want) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("no violation contains %q in %q", want, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSchemaFileRef(t *testing.T) {
+	dir := t.TempDir()
+	common := `{"$defs":{"address":{"type":"object","required":["city"],"properties":{"zip":{"$ref":"#/$defs/zip"}}},"zip":{"pattern":"^[0-9]{5}$"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "common.json"), []byte(common), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	schema := mustDecode(t, `{"properties":{"address":{"$ref":"common.json#/$defs/address"}}}`)
+
+	if errs := validateSchema(schema, mustDecode(t, `{"address":{"city":"Paris","zip":"75001"}}`), dir); len(errs) != 0 {
+		t.Errorf("valid address: got %q", errs)
+	}
+	errs := validateSchema(schema, mustDecode(t, `{"address":{"zip":"750"}}`), dir)
+	want := []string{"/address/zip: '750' does not match pattern '^[0-9]{5}$'", "/address: missing required property 'city'"}
+	if len(errs) != len(want) {
+		t.Fatalf("got %q, want %q", errs, want)
+	}
+	for i := range want {
+		if errs[i] != want[i] {
+			t.Errorf("violation %d: got %q, want %q", i, errs[i], want[i])
+		}
+	}
+}
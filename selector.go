@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The CSS selector subset used by expected_html and "css:" variables:
+//
+// Simple:      tag, *, #id, .class, [attr], [attr=value], [attr^=v], [attr$=v], [attr*=v], [attr~=v]
+// Pseudo:      :first-child, :last-child, :nth-child(n)
+// Combinators: descendant (space), child (>), and selector lists (a, b)
+// Attributes:  a trailing @attr returns the attribute instead of the text, e.g. "a.next@href"
+
+// cssCompound is a compound selector such as "div.card#main[data-id]".
+type cssCompound struct {
+	tag     string // "" or "*" for any element
+	id      string
+	classes []string
+	attrs   []cssAttr
+	pseudos []string // "first-child", "last-child", "nth-child(3)"
+}
+
+// cssAttr is an attribute condition, e.g. [type=submit]. op is "" for presence.
+type cssAttr struct {
+	name, op, value string
+}
+
+// cssSelector is a complex selector: compounds joined by combinators (" " or ">").
+// combinators[i] joins compounds[i] and compounds[i+1].
+type cssSelector struct {
+	compounds   []cssCompound
+	combinators []string
+}
+
+// selectCSS returns the elements matching a selector list in document order,
+// or the value of their attribute when the selector ends with @attr.
+func selectCSS(doc *markupNode, query string) ([]any, error) {
+	query, attr := splitSelectorAttr(query)
+	selectors, err := parseSelectorList(query)
+	if err != nil {
+		return nil, fmt.Errorf("selector '%s': %v", query, err)
+	}
+
+	var results []any
+	for _, el := range doc.descendants() {
+		for _, sel := range selectors {
+			if !sel.matches(el) {
+				continue
+			}
+			if attr == "" {
+				results = append(results, el)
+			} else if v, ok := el.attrs[attr]; ok {
+				results = append(results, v)
+			}
+			break
+		}
+	}
+	return results, nil
+}
+
+// splitSelectorAttr splits "a.next@href" into the selector and the attribute name.
+// An @ inside brackets or quotes is part of the selector.
+func splitSelectorAttr(query string) (string, string) {
+	depth := 0
+	var quote byte
+	for i := len(query) - 1; i >= 0; i-- {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ']':
+			depth++
+		case c == '[':
+			depth--
+		case c == '@' && depth == 0:
+			return strings.TrimSpace(query[:i]), strings.ToLower(strings.TrimSpace(query[i+1:]))
+		}
+	}
+	return strings.TrimSpace(query), ""
+}
+
+// parseSelectorList parses comma separated selectors.
+func parseSelectorList(query string) ([]cssSelector, error) {
+	var selectors []cssSelector
+	for part := range strings.SplitSeq(query, ",") {
+		sel, err := parseSelector(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
+
+// parseSelector parses a single complex selector.
+func parseSelector(s string) (cssSelector, error) {
+	var sel cssSelector
+	if s == "" {
+		return sel, fmt.Errorf("empty selector")
+	}
+	i := 0
+	for i < len(s) {
+		compound, next, err := parseCompound(s, i)
+		if err != nil {
+			return sel, err
+		}
+		sel.compounds = append(sel.compounds, compound)
+		i = next
+
+		// Combinator
+		combinator := ""
+		for i < len(s) && (s[i] == ' ' || s[i] == '>') {
+			if s[i] == '>' {
+				combinator = ">"
+			} else if combinator == "" {
+				combinator = " "
+			}
+			i++
+		}
+		if i < len(s) {
+			if combinator == "" {
+				return sel, fmt.Errorf("unexpected '%c' at %d", s[i], i)
+			}
+			sel.combinators = append(sel.combinators, combinator)
+		} else if combinator == ">" {
+			return sel, fmt.Errorf("missing selector after '>'")
+		}
+	}
+	return sel, nil
+}
+
+// parseCompound parses a compound selector starting at i and returns the position after it.
+func parseCompound(s string, i int) (cssCompound, int, error) {
+	var c cssCompound
+	ident := func() string {
+		start := i
+		for i < len(s) && (s[i] == '-' || s[i] == '_' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || s[i] >= '0' && s[i] <= '9') {
+			i++
+		}
+		return s[start:i]
+	}
+
+	if i < len(s) && s[i] == '*' {
+		c.tag = "*"
+		i++
+	} else {
+		c.tag = strings.ToLower(ident())
+	}
+	start := i
+	for i < len(s) {
+		switch s[i] {
+		case '#':
+			i++
+			if c.id = ident(); c.id == "" {
+				return c, i, fmt.Errorf("missing id after '#' at %d", i-1)
+			}
+		case '.':
+			i++
+			class := ident()
+			if class == "" {
+				return c, i, fmt.Errorf("missing class name after '.' at %d", i-1)
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end == -1 {
+				return c, i, fmt.Errorf("missing ']'")
+			}
+			attr, err := parseCSSAttr(s[i+1 : i+end])
+			if err != nil {
+				return c, i, err
+			}
+			c.attrs = append(c.attrs, attr)
+			i += end + 1
+		case ':':
+			i++
+			name := ident()
+			if i < len(s) && s[i] == '(' {
+				end := strings.IndexByte(s[i:], ')')
+				if end == -1 {
+					return c, i, fmt.Errorf("missing ')'")
+				}
+				name += s[i : i+end+1]
+				i += end + 1
+			}
+			if name != "first-child" && name != "last-child" && !strings.HasPrefix(name, "nth-child(") {
+				return c, i, fmt.Errorf("unsupported pseudo-class ':%s'", name)
+			}
+			if arg, isNth := strings.CutPrefix(name, "nth-child("); isNth {
+				if n, err := strconv.Atoi(strings.TrimSuffix(arg, ")")); err != nil || n < 1 {
+					return c, i, fmt.Errorf("invalid ':%s', only a position such as nth-child(2) is supported", name)
+				}
+			}
+			c.pseudos = append(c.pseudos, name)
+		default:
+			if c.tag == "" && i == start {
+				return c, i, fmt.Errorf("unexpected '%c' at %d", s[i], i)
+			}
+			return c, i, nil
+		}
+	}
+	if c.tag == "" && i == start {
+		return c, i, fmt.Errorf("empty selector")
+	}
+	return c, i, nil
+}
+
+// parseCSSAttr parses the inside of an attribute selector, e.g. `type="submit"` or `href^=https`.
+func parseCSSAttr(s string) (cssAttr, error) {
+	for _, op := range []string{"^=", "$=", "*=", "~=", "="} {
+		if name, value, found := strings.Cut(s, op); found {
+			value = strings.TrimSpace(value)
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			return cssAttr{name: cssAttrName(name), op: op, value: value}, validCSSAttr(name, s)
+		}
+	}
+	return cssAttr{name: cssAttrName(s)}, validCSSAttr(s, s)
+}
+
+func cssAttrName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// validCSSAttr checks the attribute name of the selector [attr], so unsupported operators such as |= are reported.
+func validCSSAttr(name, attr string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("empty attribute selector")
+	}
+	if i := strings.IndexFunc(name, func(r rune) bool {
+		return !(r == '-' || r == '_' || r == ':' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}); i >= 0 {
+		return fmt.Errorf("unsupported attribute selector '[%s]'", attr)
+	}
+	return nil
+}
+
+// matches reports whether an element matches the selector, checking from the rightmost compound.
+func (sel cssSelector) matches(el *markupNode) bool {
+	return sel.matchFrom(el, len(sel.compounds)-1)
+}
+
+func (sel cssSelector) matchFrom(el *markupNode, i int) bool {
+	if !sel.compounds[i].matches(el) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if sel.combinators[i-1] == ">" {
+		return el.parent != nil && el.parent.name != "" && sel.matchFrom(el.parent, i-1)
+	}
+	for p := el.parent; p != nil && p.name != ""; p = p.parent {
+		if sel.matchFrom(p, i-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cssCompound) matches(el *markupNode) bool {
+	if c.tag != "" && c.tag != "*" && !strings.EqualFold(el.name, c.tag) {
+		return false
+	}
+	if c.id != "" && el.attrs["id"] != c.id {
+		return false
+	}
+	classes := strings.Fields(el.attrs["class"])
+	for _, class := range c.classes {
+		if !slices.Contains(classes, class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		v, ok := el.attrs[a.name]
+		if !ok {
+			return false
+		}
+		switch a.op {
+		case "=":
+			ok = v == a.value
+		case "^=":
+			ok = strings.HasPrefix(v, a.value)
+		case "$=":
+			ok = strings.HasSuffix(v, a.value)
+		case "*=":
+			ok = strings.Contains(v, a.value)
+		case "~=":
+			ok = slices.Contains(strings.Fields(v), a.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(c.pseudos) > 0 {
+		siblings := el.parent.elements()
+		index := 0
+		for i, s := range siblings {
+			if s == el {
+				index = i + 1
+			}
+		}
+		for _, pseudo := range c.pseudos {
+			switch {
+			case pseudo == "first-child" && index != 1:
+				return false
+			case pseudo == "last-child" && index != len(siblings):
+				return false
+			case strings.HasPrefix(pseudo, "nth-child("):
+				n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(pseudo, "nth-child("), ")"))
+				if err != nil || index != n {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported values of the sign type
+const (
+	signNone  = "none"
+	signHMAC  = "hmac"
+	signSigV4 = "aws_sigv4"
+)
+
+// defaultStringToSign is the canonical string of the hmac signing when string_to_sign is not set.
+const defaultStringToSign = "{method}\n{path}\n{timestamp}\n{body_sha256}"
+
+// signPlaceholder matches the placeholders of string_to_sign, e.g. {method} or {header:X-Request-Id}.
+var signPlaceholder = regexp.MustCompile(`\{([a-z0-9_]+)(?::([^}]+))?\}`)
+
+// signPlaceholders are the placeholder names supported by string_to_sign.
+var signPlaceholders = []string{"method", "path", "query", "host", "timestamp", "date", "body", "body_sha256", "body_md5", "header"}
+
+// signOptions signs the requests right before they are sent, once the body and headers are final.
+// It can be set for the suite and per test; the sign of a test replaces the suite one.
+type signOptions struct {
+	Type string `json:"type"`
+	// Secret, Algorithm, StringToSign, Header, Prefix, Encoding and TimestampHeader configure the hmac signing
+	Secret          string `json:"secret,omitempty"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	StringToSign    string `json:"string_to_sign,omitempty"`
+	Header          string `json:"header,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	Encoding        string `json:"encoding,omitempty"`
+	TimestampHeader string `json:"timestamp_header,omitempty"`
+	// AccessKey, SecretKey, SessionToken, Region and Service configure the AWS Signature Version 4
+	AccessKey    string `json:"access_key,omitempty"`
+	SecretKey    string `json:"secret_key,omitempty"`
+	SessionToken string `json:"session_token,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Service      string `json:"service,omitempty"`
+}
+
+// hmacHashes maps the hmac algorithm values to their hash functions.
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// process substitutes the variables in every string option, so any of them can come from a variable.
+func (s *signOptions) process() bool {
+	fields := []*string{
+		&s.Type, &s.Secret, &s.Algorithm, &s.StringToSign, &s.Header, &s.Prefix, &s.Encoding, &s.TimestampHeader,
+		&s.AccessKey, &s.SecretKey, &s.SessionToken, &s.Region, &s.Service,
+	}
+	for _, field := range fields {
+		var ok bool
+		if *field, ok = processString(*field); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the options required by the sign type.
+func (s *signOptions) validate() error {
+	switch s.Type {
+	case signNone:
+	case signHMAC:
+		if s.Secret == "" {
+			return fmt.Errorf("hmac signing needs a secret")
+		}
+		if _, ok := hmacHashes[s.Algorithm]; !ok && s.Algorithm != "" {
+			return fmt.Errorf("unknown algorithm '%s' (use sha1, sha256 or sha512)", s.Algorithm)
+		}
+		if s.Encoding != "" && s.Encoding != "hex" && s.Encoding != "base64" {
+			return fmt.Errorf("unknown encoding '%s' (use hex or base64)", s.Encoding)
+		}
+		for _, match := range signPlaceholder.FindAllStringSubmatch(s.StringToSign, -1) {
+			if !slices.Contains(signPlaceholders, match[1]) {
+				return fmt.Errorf("unknown placeholder %s in string_to_sign", match[0])
+			}
+		}
+	case signSigV4:
+		if s.AccessKey == "" || s.SecretKey == "" || s.Region == "" || s.Service == "" {
+			return fmt.Errorf("aws_sigv4 signing needs access_key, secret_key, region and service")
+		}
+	default:
+		return fmt.Errorf("unknown sign type '%s' (use none, hmac or aws_sigv4)", s.Type)
+	}
+	return nil
+}
+
+// apply signs the request. It reads the body to hash it and puts it back for sending.
+func (s *signOptions) apply(req *http.Request) error {
+	if s == nil || s.Type == signNone {
+		return nil
+	}
+	body, err := requestBody(req)
+	if err != nil {
+		return fmt.Errorf("cannot read the body: %v", err)
+	}
+
+	now := time.Now().UTC()
+	if s.Type == signSigV4 {
+		s.signV4(req, body, now)
+	} else {
+		s.signHMAC(req, body, now)
+	}
+	return nil
+}
+
+// requestBody returns the body of the request and makes it readable again.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return data, nil
+}
+
+// signHMAC computes the HMAC of the canonical string and sets it in the signature header.
+// The placeholders of string_to_sign are:
+// 1. {method}, {path} (escaped), {query} (raw query) and {host}.
+// 2. {timestamp} (Unix seconds) and {date} (RFC 3339), sent in timestamp_header if set.
+// 3. {body}, {body_sha256} and {body_md5} (hex).
+// 4. {header:Name}, the value of a request header.
+func (s *signOptions) signHMAC(req *http.Request, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	if s.TimestampHeader != "" {
+		req.Header.Set(s.TimestampHeader, timestamp)
+	}
+
+	template := s.StringToSign
+	if template == "" {
+		template = defaultStringToSign
+	}
+	canonical := signPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		parts := signPlaceholder.FindStringSubmatch(match)
+		switch parts[1] {
+		case "method":
+			return req.Method
+		case "path":
+			return req.URL.EscapedPath()
+		case "query":
+			return req.URL.RawQuery
+		case "host":
+			return requestHost(req)
+		case "timestamp":
+			return timestamp
+		case "date":
+			return now.Format(time.RFC3339)
+		case "body":
+			return string(body)
+		case "body_sha256":
+			sum := sha256.Sum256(body)
+			return hex.EncodeToString(sum[:])
+		case "body_md5":
+			sum := md5.Sum(body)
+			return hex.EncodeToString(sum[:])
+		case "header":
+			return req.Header.Get(parts[2])
+		}
+		return match
+	})
+
+	newHash, ok := hmacHashes[s.Algorithm]
+	if !ok {
+		newHash = sha256.New
+	}
+	mac := hmac.New(newHash, []byte(s.Secret))
+	mac.Write([]byte(canonical))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	if s.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	header := s.Header
+	if header == "" {
+		header = "X-Signature"
+	}
+	req.Header.Set(header, s.Prefix+signature)
+}
+
+// signV4 signs the request with AWS Signature Version 4 (Authorization header).
+// Every header of the request is signed, with the host.
+func (s *signOptions) signV4(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := strings.Join([]string{now.Format("20060102"), s.Region, s.Service, "aws4_request"}, "/")
+	bodyHash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(bodyHash[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	if s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	headers := map[string]string{"host": requestHost(req)}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4Path(req.URL, s.Service != "s3"),
+		sigV4Query(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{now.Format("20060102"), s.Region, s.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// sigV4Path returns the canonical URI. Services other than S3 encode the path segments twice.
+func sigV4Path(u *url.URL, doubleEncode bool) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segment = awsEscape(segment)
+		if doubleEncode {
+			segment = awsEscape(segment)
+		}
+		segments[i] = segment
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4Query returns the canonical query string: parameters sorted by name, then value.
+func sigV4Query(u *url.URL) string {
+	query := u.Query()
+	escaped := map[string]string{}
+	for name := range query {
+		escaped[awsEscape(name)] = name
+	}
+	var params []string
+	for _, name := range slices.Sorted(maps.Keys(escaped)) {
+		values := make([]string, len(query[escaped[name]]))
+		for i, value := range query[escaped[name]] {
+			values[i] = awsEscape(value)
+		}
+		slices.Sort(values)
+		for _, value := range values {
+			params = append(params, name+"="+value)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// awsEscape percent-encodes everything except the RFC 3986 unreserved characters.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// requestHost returns the host the request is sent to, as in the Host header.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sigV4Suite are the credentials and date of the AWS Signature Version 4 test suite.
+var sigV4Suite = signOptions{
+	Type:      signSigV4,
+	AccessKey: "AKIDEXAMPLE",
+	SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	Region:    "us-east-1",
+	Service:   "service",
+}
+
+func TestSignV4ReferenceVectors(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	const credential = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		headers map[string]string
+		body    string
+		want    string
+	}{
+		{
+			name:   "get-vanilla",
+			method: "GET",
+			url:    "https://example.amazonaws.com/",
+			want:   "SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: "GET",
+			url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			want:   "SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "get-vanilla-empty-query-key",
+			method: "GET",
+			url:    "https://example.amazonaws.com/?Param1=value1",
+			want:   "SignedHeaders=host;x-amz-date, Signature=a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		},
+		{
+			name:   "post-vanilla",
+			method: "POST",
+			url:    "https://example.amazonaws.com/",
+			want:   "SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:    "post-x-www-form-urlencoded",
+			method:  "POST",
+			url:     "https://example.amazonaws.com/",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:    "Param1=value1",
+			want:    "SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			sigV4Suite.signV4(req, []byte(tt.body), now)
+			if got := req.Header.Get("Authorization"); got != credential+tt.want {
+				t.Errorf("got  %s\nwant %s", got, credential+tt.want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date: got %s", got)
+			}
+		})
+	}
+}
+
+func TestSignV4Headers(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	s3 := signOptions{Type: signSigV4, AccessKey: "AK", SecretKey: "SK", SessionToken: "session", Region: "eu-west-1", Service: "s3"}
+	req, _ := http.NewRequest(http.MethodPut, "https://bucket.s3.amazonaws.com/a b.txt", nil)
+	s3.signV4(req, []byte("hello"), now)
+
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "session" {
+		t.Errorf("X-Amz-Security-Token: got %q", got)
+	}
+	// S3 needs the payload hash header, and it is signed like the others
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("X-Amz-Content-Sha256: got %q", got)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") ||
+		!strings.Contains(auth, "Credential=AK/20150830/eu-west-1/s3/aws4_request") {
+		t.Errorf("Authorization: got %s", auth)
+	}
+
+	tests := []struct {
+		path         string
+		doubleEncode bool
+		want         string
+	}{
+		{"/", true, "/"},
+		{"", true, "/"},
+		{"/a b/c", false, "/a%20b/c"},
+		{"/a b/c", true, "/a%2520b/c"},
+		{"/~user/ü", false, "/~user/%C3%BC"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com", nil)
+		req.URL.Path = tt.path
+		if got := sigV4Path(req.URL, tt.doubleEncode); got != tt.want {
+			t.Errorf("sigV4Path(%q, %v): got %s, want %s", tt.path, tt.doubleEncode, got, tt.want)
+		}
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "https://example.amazonaws.com/?b=2&a=z&a=y&c=a b&d=%2F", nil)
+	if got, want := sigV4Query(req.URL), "a=y&a=z&b=2&c=a%20b&d=%2F"; got != want {
+		t.Errorf("sigV4Query: got %s, want %s", got, want)
+	}
+}
+
+func TestSignHMAC(t *testing.T) {
+	now := time.Unix(1700000000, 0).UTC()
+	tests := []struct {
+		name   string
+		sign   signOptions
+		header string
+		want   string
+	}{
+		{
+			// HMAC-SHA256 reference value of "The quick brown fox jumps over the lazy dog" with the key "key"
+			name:   "body",
+			sign:   signOptions{Type: signHMAC, Secret: "key", StringToSign: "{body}"},
+			header: "X-Signature",
+			want:   "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		},
+		{
+			name:   "sha1 base64 with prefix",
+			sign:   signOptions{Type: signHMAC, Secret: "key", StringToSign: "{body}", Algorithm: "sha1", Encoding: "base64", Header: "Authorization", Prefix: "HMAC "},
+			header: "Authorization",
+			want:   "HMAC 3nybhbi3iqa8ino29wqQcBydtNk=",
+		},
+		{
+			name:   "placeholders",
+			sign:   signOptions{Type: signHMAC, Secret: "s", StringToSign: "{method} {path}?{query} {host} {timestamp} {date} {header:X-Request-Id} {body_md5}"},
+			header: "X-Signature",
+			want: hex.EncodeToString(hmacSHA256([]byte("s"),
+				"POST /orders/a%20b?page=2 api.local 1700000000 2023-11-14T22:13:20Z req-7 9e107d9d372bb6826bd81d3542a419d6")),
+		},
+		{
+			name:   "default string to sign",
+			sign:   signOptions{Type: signHMAC, Secret: "s", TimestampHeader: "X-Timestamp"},
+			header: "X-Signature",
+			want: hex.EncodeToString(hmacSHA256([]byte("s"),
+				"POST\n/orders/a%20b\n1700000000\nd7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sign.validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			req, _ := http.NewRequest(http.MethodPost, "http://api.local/orders/a%20b?page=2", strings.NewReader("The quick brown fox jumps over the lazy dog"))
+			req.Header.Set("X-Request-Id", "req-7")
+			body, err := requestBody(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.sign.signHMAC(req, body, now)
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%s: got %s, want %s", tt.header, got, tt.want)
+			}
+			if tt.sign.TimestampHeader != "" && req.Header.Get(tt.sign.TimestampHeader) != "1700000000" {
+				t.Errorf("%s: got %q", tt.sign.TimestampHeader, req.Header.Get(tt.sign.TimestampHeader))
+			}
+		})
+	}
+}
+
+func TestSignApplyKeepsBody(t *testing.T) {
+	sign := signOptions{Type: signHMAC, Secret: "s"}
+	req, _ := http.NewRequest(http.MethodPost, "http://api.local/", strings.NewReader(`{"a":1}`))
+	if err := sign.apply(req); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		body, _ := req.GetBody()
+		if data, _ := io.ReadAll(body); string(data) != `{"a":1}` {
+			t.Errorf("GetBody: got %q", data)
+		}
+	}
+	if data, _ := io.ReadAll(req.Body); string(data) != `{"a":1}` {
+		t.Errorf("Body: got %q", data)
+	}
+}
+
+func TestSignValidate(t *testing.T) {
+	tests := []struct {
+		sign signOptions
+		err  string
+	}{
+		{signOptions{Type: "oauth1"}, "unknown sign type 'oauth1'"},
+		{signOptions{Type: signHMAC}, "hmac signing needs a secret"},
+		{signOptions{Type: signHMAC, Secret: "s", Algorithm: "md5"}, "unknown algorithm 'md5'"},
+		{signOptions{Type: signHMAC, Secret: "s", Encoding: "base32"}, "unknown encoding 'base32'"},
+		{signOptions{Type: signHMAC, Secret: "s", StringToSign: "{method}\n{uri}"}, "unknown placeholder {uri} in string_to_sign"},
+		{signOptions{Type: signSigV4, AccessKey: "AK", SecretKey: "SK", Region: "us-east-1"}, "aws_sigv4 signing needs access_key, secret_key, region and service"},
+	}
+	for _, tt := range tests {
+		if err := tt.sign.validate(); err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%+v: got %v, want %q", tt.sign, err, tt.err)
+		}
+	}
+}
+
+func TestSignProcess(t *testing.T) {
+	defer useTest(&test{})()
+	defer useVariables(map[string]any{"secret": "s3cr3t", "header": "X-Signature", "service": "execute-api", "region": "eu-west-1", "algo": "sha512"})()
+
+	s := signOptions{
+		Type: signHMAC, Secret: "$secret$", Algorithm: "$algo$", StringToSign: "{method}\n$region$",
+		Header: "$header$", Prefix: "v1=$region$:", Encoding: "hex", TimestampHeader: "X-$header$-Time",
+		AccessKey: "AK", SecretKey: "$secret$", Region: "$region$", Service: "$service$",
+	}
+	if !s.process() {
+		t.Fatal("process failed")
+	}
+	want := signOptions{
+		Type: signHMAC, Secret: "s3cr3t", Algorithm: "sha512", StringToSign: "{method}\neu-west-1",
+		Header: "X-Signature", Prefix: "v1=eu-west-1:", Encoding: "hex", TimestampHeader: "X-X-Signature-Time",
+		AccessKey: "AK", SecretKey: "s3cr3t", Region: "eu-west-1", Service: "execute-api",
+	}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+
+	missing := signOptions{Type: signSigV4, Service: "$missing$"}
+	if missing.process() {
+		t.Error("an unknown variable must fail")
+	}
+}
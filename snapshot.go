@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// snapshotDir is the directory (relative to the test file) where snapshots are stored.
+const snapshotDir = "__snapshots__"
+
+// snapshotFileName matches characters that are not safe in snapshot file names.
+var snapshotFileName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// defaultSnapshotHeaders are the response headers stored in a snapshot when the test does not set snapshot_headers.
+var defaultSnapshotHeaders = []string{"Content-Type"}
+
+// responseSnapshot builds the stored form of a response: its status code, the selected headers
+// and the body. Non-JSON bodies are stored as a JSON string so every snapshot has the same format.
+func responseSnapshot(res *http.Response, body []byte, headers []string) map[string]any {
+	if headers == nil {
+		headers = defaultSnapshotHeaders
+	}
+	selected := map[string]any{}
+	for _, name := range headers {
+		if values := res.Header.Values(name); len(values) > 0 {
+			selected[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+		}
+	}
+	decoded, err := decodeJSON(body)
+	if err != nil {
+		decoded = string(body)
+	}
+	return map[string]any{
+		"status":  json.Number(strconv.Itoa(res.StatusCode)),
+		"headers": selected,
+		"body":    decoded,
+	}
+}
+
+// checkSnapshot compares a response snapshot (see responseSnapshot) with the stored snapshot of the test.
+// With update set, the response is recorded instead. A missing snapshot fails the test, so a run
+// without recorded snapshots (e.g. in CI) cannot pass by accident.
+// Paths in ignore (the suite's snapshot_ignore plus the test's own) are body paths; they are removed
+// from both sides before comparing.
+func checkSnapshot(suiteName string, suiteIgnore []string, response map[string]any, update bool) bool {
+	file := snapshotPath(suiteName)
+
+	if update {
+		if err := writeSnapshot(file, response); err != nil {
+			LogMsg("[FAIL] Could not write snapshot %s: %v\n", file, err)
+			return false
+		}
+		LogMsg("[NOTE] Snapshot recorded at %s\n", file)
+		return true
+	}
+
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		LogMsg("[FAIL] No snapshot at %s. Run with -update-snapshots to record it.\n", file)
+		return false
+	}
+	if err != nil {
+		LogMsg("[FAIL] Could not read snapshot %s: %v\n", file, err)
+		return false
+	}
+	expected, err := decodeJSON(content)
+	if err != nil {
+		LogMsg("[FAIL] Snapshot %s is not valid JSON: %v\n", file, err)
+		return false
+	}
+
+	var actual any = response
+	for _, p := range slices.Concat(suiteIgnore, t.SnapshotIgnore) {
+		expected = removePath(expected, joinPath("body", p))
+		actual = removePath(actual, joinPath("body", p))
+	}
+
+	diffs := snapshotDiff(expected, actual, "")
+	for _, d := range diffs {
+		LogMsg("[Snapshot Diff] %s\n", d)
+	}
+	return len(diffs) == 0
+}
+
+// snapshotPath returns the snapshot file of the current test: __snapshots__/<suite>_<snapshot_name or test number>.json
+func snapshotPath(suiteName string) string {
+	name := t.SnapshotName
+	if name == "" {
+		name = "test_" + strconv.Itoa(t.Number)
+	}
+	name = snapshotFileName.ReplaceAllString(suiteName+"_"+name, "_")
+	return filepath.Join(filepath.Dir(*path), snapshotDir, name+".json")
+}
+
+// writeSnapshot stores the value as indented JSON, creating the snapshot directory if needed.
+func writeSnapshot(file string, value any) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(b, '\n'), 0o644)
+}
+
+// snapshotDiff compares two decoded JSON values exactly (strict keys, ordered arrays)
+// and returns one line per difference. Unlike validateBody it never interprets
+// matcher strings, as both sides are real responses.
+func snapshotDiff(expected, actual any, at string) []string {
+	location := at
+	if location == "" {
+		location = "(root)"
+	}
+
+	switch exp := expected.(type) {
+	case map[string]any:
+		act, ok := actual.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected object, got %s", location, jsonType(actual))}
+		}
+		var diffs []string
+		for _, k := range slices.Sorted(maps.Keys(exp)) {
+			vAct, exists := act[k]
+			if !exists {
+				diffs = append(diffs, fmt.Sprintf("%s: missing key '%s'", location, k))
+				continue
+			}
+			diffs = append(diffs, snapshotDiff(exp[k], vAct, joinPath(at, k))...)
+		}
+		for _, k := range slices.Sorted(maps.Keys(act)) {
+			if _, exists := exp[k]; !exists {
+				diffs = append(diffs, fmt.Sprintf("%s: unexpected key '%s'", location, k))
+			}
+		}
+		return diffs
+
+	case []any:
+		act, ok := actual.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected array, got %s", location, jsonType(actual))}
+		}
+		var diffs []string
+		if len(exp) != len(act) {
+			diffs = append(diffs, fmt.Sprintf("%s: expected %d items, got %d", location, len(exp), len(act)))
+		}
+		for i := 0; i < len(exp) && i < len(act); i++ {
+			diffs = append(diffs, snapshotDiff(exp[i], act[i], fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return diffs
+
+	default:
+		if !jsonEqual(expected, actual) {
+			return []string{fmt.Sprintf("%s: expected %s, got %s", location, scalarString(expected), scalarString(actual))}
+		}
+		return nil
+	}
+}
+
+// joinPath appends a key to a dot-notation path.
+func joinPath(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+// removePath deletes the value at a dot-notation path (same syntax as var_to_store, e.g. "user.id",
+// "items[0].id") from data. "[*]" applies the rest of the path to every item of an array.
+// Missing paths are ignored. It returns the updated data.
+func removePath(data any, path string) any {
+	return updatePath(data, path, func(any) (any, bool) { return nil, false })
+}
+
+// updatePath replaces the value at a dot-notation path (same syntax as removePath) with the result
+// of update, or deletes it when update returns false. Missing paths are ignored.
+// It returns the updated data.
+func updatePath(data any, path string, update func(any) (any, bool)) any {
+	if path == "" {
+		if value, keep := update(data); keep {
+			return value
+		}
+		return data
+	}
+
+	// Split off the first key or index
+	var head, rest string
+	if strings.HasPrefix(path, "[") {
+		closeIdx := strings.Index(path, "]")
+		if closeIdx == -1 {
+			return data
+		}
+		head, rest = path[:closeIdx+1], path[closeIdx+1:]
+	} else {
+		end := strings.IndexAny(path, ".[")
+		if end == -1 {
+			end = len(path)
+		}
+		head, rest = path[:end], path[end:]
+	}
+	rest = strings.TrimPrefix(rest, ".")
+
+	// --- CASE A: Array index or wildcard ---
+	if strings.HasPrefix(head, "[") {
+		arr, ok := data.([]any)
+		if !ok {
+			return data
+		}
+		indexStr := head[1 : len(head)-1]
+		if indexStr == "*" {
+			kept := []any{}
+			for _, item := range arr {
+				if rest != "" {
+					kept = append(kept, updatePath(item, rest, update))
+				} else if value, keep := update(item); keep {
+					kept = append(kept, value)
+				}
+			}
+			return kept
+		}
+		index, err := strconv.Atoi(indexStr)
+		if err != nil || index < 0 || index >= len(arr) {
+			return data
+		}
+		if rest == "" {
+			if value, keep := update(arr[index]); keep {
+				arr[index] = value
+				return arr
+			}
+			return slices.Delete(arr, index, index+1)
+		}
+		arr[index] = updatePath(arr[index], rest, update)
+		return arr
+	}
+
+	// --- CASE B: Map key ---
+	m, ok := data.(map[string]any)
+	if !ok {
+		return data
+	}
+	val, exists := m[head]
+	if !exists {
+		return data
+	}
+	if rest == "" {
+		if value, keep := update(val); keep {
+			m[head] = value
+		} else {
+			delete(m, head)
+		}
+		return m
+	}
+	m[head] = updatePath(val, rest, update)
+	return m
+}
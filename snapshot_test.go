@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useSuitePath makes file the path of the test file, which snapshots and relative files are resolved from.
+func useSuitePath(file string) (restore func()) {
+	previous := path
+	path = &file
+	return func() { path = previous }
+}
+
+// snapshotResponse builds a JSON response with the headers given as name/value pairs.
+func snapshotResponse(status int, body string, headers ...string) (*http.Response, []byte) {
+	res := &http.Response{StatusCode: status, Header: http.Header{}}
+	for i := 0; i+1 < len(headers); i += 2 {
+		res.Header.Add(headers[i], headers[i+1])
+	}
+	return res, []byte(body)
+}
+
+func TestResponseSnapshot(t *testing.T) {
+	res, body := snapshotResponse(201, `{"id": 7}`, "Content-Type", "application/json", "Date", "today", "Vary", "Accept", "Vary", "Origin")
+	got, _ := json.Marshal(responseSnapshot(res, body, nil))
+	if want := `{"body":{"id":7},"headers":{"Content-Type":"application/json"},"status":201}`; string(got) != want {
+		t.Errorf("default headers: got %s, want %s", got, want)
+	}
+	got, _ = json.Marshal(responseSnapshot(res, body, []string{"vary", "X-Missing"}))
+	if want := `{"body":{"id":7},"headers":{"Vary":"Accept, Origin"},"status":201}`; string(got) != want {
+		t.Errorf("selected headers: got %s, want %s", got, want)
+	}
+	res, body = snapshotResponse(500, "Internal Server Error")
+	got, _ = json.Marshal(responseSnapshot(res, body, []string{}))
+	if want := `{"body":"Internal Server Error","headers":{},"status":500}`; string(got) != want {
+		t.Errorf("text body: got %s, want %s", got, want)
+	}
+}
+
+func TestCheckSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	defer useSuitePath(filepath.Join(dir, "suite.json"))()
+	current := &test{Number: 3, SnapshotIgnore: []string{"items[*].id"}}
+	defer useTest(current)()
+	file := filepath.Join(dir, snapshotDir, "users_api_test_3.json")
+
+	res, body := snapshotResponse(200, `{"created_at": "monday", "items": [{"id": 1, "name": "a"}]}`, "Content-Type", "application/json")
+	if checkSnapshot("users api", nil, responseSnapshot(res, body, nil), false) {
+		t.Error("a missing snapshot must fail without -update-snapshots")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("a missing snapshot must not be recorded without -update-snapshots: %v", err)
+	}
+
+	if !checkSnapshot("users api", nil, responseSnapshot(res, body, nil), true) {
+		t.Fatal("recording failed")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"status": 200`) || !strings.Contains(string(content), `"Content-Type": "application/json"`) {
+		t.Errorf("recorded snapshot:\n%s", content)
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		header string
+		want   bool
+	}{
+		{"same response", 200, `{"created_at": "monday", "items": [{"id": 1, "name": "a"}]}`, "application/json", true},
+		{"ignored paths", 200, `{"created_at": "tuesday", "items": [{"id": 2, "name": "a"}]}`, "application/json", true},
+		{"body change", 200, `{"created_at": "monday", "items": [{"id": 1, "name": "b"}]}`, "application/json", false},
+		{"status change", 202, `{"created_at": "monday", "items": [{"id": 1, "name": "a"}]}`, "application/json", false},
+		{"header change", 200, `{"created_at": "monday", "items": [{"id": 1, "name": "a"}]}`, "text/plain", false},
+	}
+	for _, tt := range tests {
+		current.Logs = nil
+		res, body := snapshotResponse(tt.status, tt.body, "Content-Type", tt.header)
+		// The suite's snapshot_ignore applies next to the test's own
+		if got := checkSnapshot("users api", []string{"created_at"}, responseSnapshot(res, body, nil), false); got != tt.want {
+			t.Errorf("%s: got %v, want %v (%q)", tt.name, got, tt.want, current.Logs)
+		}
+	}
+
+	os.WriteFile(file, []byte("{broken"), 0o644)
+	if checkSnapshot("users api", nil, responseSnapshot(res, body, nil), false) {
+		t.Error("an invalid snapshot must fail")
+	}
+}
+
+func TestSnapshotPath(t *testing.T) {
+	defer useSuitePath(filepath.Join("suites", "api.json"))()
+	defer useTest(&test{Number: 12})()
+	if got, want := snapshotPath("My API / v2"), filepath.Join("suites", snapshotDir, "My_API_v2_test_12.json"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	defer useTest(&test{Number: 12, SnapshotName: "list users"})()
+	if got, want := snapshotPath("api"), filepath.Join("suites", snapshotDir, "api_list_users.json"); got != want {
+		t.Errorf("snapshot_name: got %s, want %s", got, want)
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	tests := []struct {
+		expected string
+		actual   string
+		want     []string
+	}{
+		{`{"a": 1, "b": [1, 2]}`, `{"b": [1, 2], "a": 1.0}`, nil},
+		{`{"a": 1}`, `{"a": 2}`, []string{"a: expected 1, got 2"}},
+		{`{"a": 1, "b": 2}`, `{"a": 1, "c": 3}`, []string{"(root): missing key 'b'", "(root): unexpected key 'c'"}},
+		{`[1, 2]`, `[2, 1]`, []string{"[0]: expected 1, got 2", "[1]: expected 2, got 1"}},
+		{`[1, 2]`, `[1]`, []string{"(root): expected 2 items, got 1"}},
+		{`{"u": {"tags": ["a"]}}`, `{"u": {"tags": "a"}}`, []string{"u.tags: expected array, got string"}},
+		{`{"u": {}}`, `{"u": null}`, []string{"u: expected object, got null"}},
+		// Matcher strings are plain values in a snapshot
+		{`{"id": "gt:0"}`, `{"id": 5}`, []string{"id: expected gt:0, got 5"}},
+		{`{"big": 12345678901234567890}`, `{"big": 12345678901234567891}`, []string{"big: expected 12345678901234567890, got 12345678901234567891"}},
+	}
+	for _, tt := range tests {
+		if got := snapshotDiff(mustDecode(t, tt.expected), mustDecode(t, tt.actual), ""); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s against %s: got %q, want %q", tt.expected, tt.actual, got, tt.want)
+		}
+	}
+}
+
+func TestRemovePath(t *testing.T) {
+	tests := []struct {
+		data string
+		path string
+		want string
+	}{
+		{`{"a": 1, "b": 2}`, "a", `{"b": 2}`},
+		{`{"a": {"b": 1, "c": 2}}`, "a.b", `{"a": {"c": 2}}`},
+		{`{"items": [{"id": 1, "n": "a"}, {"id": 2, "n": "b"}]}`, "items[*].id", `{"items": [{"n": "a"}, {"n": "b"}]}`},
+		{`{"items": [1, 2, 3]}`, "items[1]", `{"items": [1, 3]}`},
+		{`{"items": [1, 2, 3]}`, "items[*]", `{"items": []}`},
+		{`{"items": [{"id": 1}, {"id": 2}]}`, "items[1].id", `{"items": [{"id": 1}, {}]}`},
+		{`[[1, 2], [3]]`, "[*][0]", `[[2], []]`},
+		{`{"a": 1}`, "missing.deeper", `{"a": 1}`},
+		{`{"a": [1]}`, "a[5]", `{"a": [1]}`},
+		{`{"a": [1]}`, "a[x]", `{"a": [1]}`},
+		{`{"a": "text"}`, "a.b", `{"a": "text"}`},
+		{`{"a": [1]}`, "a[0", `{"a": [1]}`},
+	}
+	for _, tt := range tests {
+		got := removePath(mustDecode(t, tt.data), tt.path)
+		if want := mustDecode(t, tt.want); !jsonEqual(got, want) {
+			b, _ := json.Marshal(got)
+			t.Errorf("%s without %s: got %s, want %s", tt.data, tt.path, b, tt.want)
+		}
+	}
+}
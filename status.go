@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// matchStatus reports whether the HTTP status code satisfies the expected_status configuration.
+// It supports:
+// 1. Numeric codes: 200
+// 2. Status strings (only the code is compared): "200", "200 OK", "200 Ok"
+// 3. Class wildcards: "2xx"
+// 4. Ranges (inclusive): "200-299"
+// 5. Lists of any of the above: [200, 201, "3xx"]
+// A missing or empty expected_status matches no status, so every test has to state the one it expects.
+func matchStatus(expected any, code int) bool {
+	if code == 0 {
+		// No response was received
+		return false
+	}
+
+	switch exp := expected.(type) {
+	case nil:
+		return false
+	case float64:
+		return exp == float64(code)
+	case int:
+		return exp == code
+	case json.Number:
+		// 200 and 200.0 are the same code, 200.9 is none
+		n, err := exp.Float64()
+		return err == nil && n == float64(code)
+	case string:
+		return matchStatusString(exp, code)
+	case []any:
+		for _, item := range exp {
+			if matchStatus(item, code) {
+				return true
+			}
+		}
+		return false
+	default:
+		LogMsg("[NOTE] Unsupported expected_status type %T\n", expected)
+		return false
+	}
+}
+
+// expectedStatus is the expected_status the test is checked against: WebSocket tests
+// expect 101 Switching Protocols unless they set their own.
+func (t *test) expectedStatus() any {
+	if t.ExpectedStatus == nil && t.WebSocket != nil {
+		return http.StatusSwitchingProtocols
+	}
+	return t.ExpectedStatus
+}
+
+// matchStatusString handles the string forms of expected_status (code, status line, wildcard, range).
+func matchStatusString(exp string, code int) bool {
+	exp = strings.TrimSpace(exp)
+	if exp == "" {
+		return false
+	}
+
+	// CASE A: Class wildcard (e.g., "2xx", "4XX")
+	lower := strings.ToLower(exp)
+	if len(lower) == 3 && strings.HasSuffix(lower, "xx") {
+		class, err := strconv.Atoi(lower[:1])
+		if err != nil {
+			LogMsg("[NOTE] Invalid status class '%s'\n", exp)
+			return false
+		}
+		return code/100 == class
+	}
+
+	// CASE B: Range (e.g., "200-299")
+	if lo, hi, found := strings.Cut(exp, "-"); found {
+		low, errLow := strconv.Atoi(strings.TrimSpace(lo))
+		high, errHigh := strconv.Atoi(strings.TrimSpace(hi))
+		if errLow != nil || errHigh != nil {
+			LogMsg("[NOTE] Invalid status range '%s'\n", exp)
+			return false
+		}
+		return code >= low && code <= high
+	}
+
+	// CASE C: Code or status line (e.g., "200" or "200 OK"). The reason phrase is ignored.
+	codeStr, _, _ := strings.Cut(exp, " ")
+	want, err := strconv.Atoi(codeStr)
+	if err != nil {
+		LogMsg("[NOTE] Invalid expected_status '%s'\n", exp)
+		return false
+	}
+	return want == code
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMatchStatus(t *testing.T) {
+	tests := []struct {
+		expected any
+		code     int
+		want     bool
+	}{
+		{200, 200, true},
+		{200, 201, false},
+		{float64(201), 201, true},
+		{201.5, 201, false},
+		{json.Number("204"), 204, true},
+		{json.Number("204.0"), 204, true},
+		{json.Number("204.9"), 204, false},
+		{json.Number("abc"), 204, false},
+		{"200", 200, true},
+		{"200 OK", 200, true},
+		{" 404 Not Found ", 404, true},
+		{"2xx", 204, true},
+		{"2XX", 302, false},
+		{"5xx", 503, true},
+		{"ax", 200, false},
+		{"200-204", 200, true},
+		{"200-204", 204, true},
+		{"200-204", 205, false},
+		{"200-", 200, false},
+		{"OK", 200, false},
+		{"", 200, false},
+		{[]any{json.Number("200"), "3xx"}, 302, true},
+		{[]any{json.Number("200"), "3xx"}, 404, false},
+		{[]any{}, 200, false},
+		{nil, 200, false},
+		{true, 200, false},
+		// No response was received
+		{"2xx", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%T %v %d", tt.expected, tt.expected, tt.code), func(t *testing.T) {
+			if got := matchStatus(tt.expected, tt.code); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectedStatus(t *testing.T) {
+	tests := []struct {
+		test test
+		want any
+	}{
+		{test{}, nil},
+		{test{ExpectedStatus: "2xx"}, "2xx"},
+		{test{WebSocket: &websocketOptions{}}, 101},
+		{test{ExpectedStatus: json.Number("401"), WebSocket: &websocketOptions{}}, json.Number("401")},
+	}
+	for _, tt := range tests {
+		if got := tt.test.expectedStatus(); got != tt.want {
+			t.Errorf("%+v: got %v, want %v", tt.test.ExpectedStatus, got, tt.want)
+		}
+	}
+}
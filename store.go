@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"maps"
 	"strconv"
@@ -28,6 +27,27 @@ func storeBodyVariables(testNo int, body []byte, variables map[string]any, toSto
 		LogMsg("Body is empty, skipping.")
 		return true
 	}
+	// XPath and CSS selector paths extract from XML/HTML bodies
+	jsonPaths := map[string]string{}
+	for k, v := range toStore {
+		if !strings.HasPrefix(v, xpathPrefix) && !strings.HasPrefix(v, cssPrefix) {
+			jsonPaths[k] = v
+			continue
+		}
+		keyName := fmt.Sprintf("test_%d_%s", testNo, k)
+		varValue, ok := extractMarkup(body, v)
+		if !ok {
+			LogMsg("Failed to extract '%s' (path: %s).\n All the tests referencing this variable might fail.\n", k, v)
+			continue
+		}
+		variables[keyName] = varValue
+		LogMsg("[NOTE] Stored %s = %v\n", keyName, varValue)
+	}
+	if len(jsonPaths) == 0 {
+		return true
+	}
+	toStore = jsonPaths
+
 	// Check if it looks like JSON (starts with { or [)
 	if body[0] != '{' && body[0] != '[' {
 		LogMsg("While storing variables, body does not look like JSON (starts with '%c'), so skipping storing of variables.\n", body[0])
@@ -36,8 +56,9 @@ func storeBodyVariables(testNo int, body []byte, variables map[string]any, toSto
 
 	// 2. Unmarshal into 'any'.
 	// This handles both Objects (map[string]any) and Arrays ([]any) automatically.
-	var bodyData any
-	if err := json.Unmarshal(body, &bodyData); err != nil {
+	// Numbers are kept as json.Number so extracted IDs keep all their digits
+	bodyData, err := decodeJSON(body)
+	if err != nil {
 		LogMsg("Error in Unmarshal of the body. Err: %v\n", err)
 		return false
 	}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+)
+
+// requestTiming breaks the duration of a single HTTP request into phases using httptrace.
+// Phases that did not happen (e.g., DNS and Connect on a reused connection, TLS on plain HTTP) stay zero.
+type requestTiming struct {
+	DNS      time.Duration `json:"dns"`       // DNS lookup
+	Connect  time.Duration `json:"connect"`   // TCP connect
+	TLS      time.Duration `json:"tls"`       // TLS handshake
+	TTFB     time.Duration `json:"ttfb"`      // from the start of the request to the first response byte
+	BodyRead time.Duration `json:"body_read"` // from the first response byte to the end of the body
+	Total    time.Duration `json:"total"`     // whole request, including body read
+	Reused   bool          `json:"reused"`    // connection was taken from the pool
+
+	start, dnsStart, connectStart, tlsStart, firstByte time.Time
+}
+
+// trace returns the httptrace hooks that record the phases. It also marks the start of the request.
+func (rt *requestTiming) trace() *httptrace.ClientTrace {
+	rt.start = time.Now()
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.Reused = info.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			rt.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			rt.DNS = time.Since(rt.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			rt.connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			rt.Connect = time.Since(rt.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			rt.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			rt.TLS = time.Since(rt.tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			rt.firstByte = time.Now()
+			rt.TTFB = rt.firstByte.Sub(rt.start)
+		},
+	}
+}
+
+// done marks the end of the body read and computes the remaining phases.
+func (rt *requestTiming) done() {
+	end := time.Now()
+	rt.Total = end.Sub(rt.start)
+	if !rt.firstByte.IsZero() {
+		rt.BodyRead = end.Sub(rt.firstByte)
+	}
+}
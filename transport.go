@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tlsOptions configures the TLS client of the requests. They can be set for the suite and per test;
+// the options set on a test override the suite ones.
+type tlsOptions struct {
+	// CA is a PEM bundle of the certificate authorities trusted in addition to the system ones
+	CA string `json:"ca,omitempty"`
+	// Cert and Key are the PEM client certificate and private key for mutual TLS
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// ServerName overrides the name used to verify the server certificate (and sent as SNI)
+	ServerName string `json:"server_name,omitempty"`
+	// MinVersion is the minimum TLS version: "1.0", "1.1", "1.2" or "1.3"
+	MinVersion string `json:"min_version,omitempty"`
+	// InsecureSkipVerify is a pointer so a test can set it back to false when the suite enables it
+	InsecureSkipVerify *bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// networkOptions route the requests of the whole suite. They are set in the suite config
+// and overridden by the command line flags.
+type networkOptions struct {
+	// Proxy is the URL of an HTTP(S) or SOCKS5 proxy (e.g. "http://127.0.0.1:8888").
+	// Without it the HTTP_PROXY / HTTPS_PROXY / NO_PROXY environment variables apply.
+	Proxy string `json:"proxy,omitempty"`
+	// Resolve pins host names to addresses like curl --resolve: "host:port:address" ("*" matches any port)
+	Resolve []string `json:"resolve,omitempty"`
+	// UnixSocket sends every request to a Unix domain socket; the URL host is only used for the Host header
+	// (a relative path is resolved from the directory of the suite file)
+	UnixSocket string `json:"unix_socket,omitempty"`
+}
+
+// tlsVersions maps min_version values to their crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// transports caches the transports by their options, so tests with the same options share connections.
+var transports = map[string]*http.Transport{}
+
+// mergeTLS returns the suite options overridden by the options set on the test.
+func mergeTLS(suite, test *tlsOptions) tlsOptions {
+	var merged tlsOptions
+	if suite != nil {
+		merged = *suite
+	}
+	if test == nil {
+		return merged
+	}
+	if test.CA != "" {
+		merged.CA = test.CA
+	}
+	if test.Cert != "" {
+		merged.Cert = test.Cert
+	}
+	if test.Key != "" {
+		merged.Key = test.Key
+	}
+	if test.ServerName != "" {
+		merged.ServerName = test.ServerName
+	}
+	if test.MinVersion != "" {
+		merged.MinVersion = test.MinVersion
+	}
+	if test.InsecureSkipVerify != nil {
+		merged.InsecureSkipVerify = test.InsecureSkipVerify
+	}
+	return merged
+}
+
+// getTransport returns the transport for the given TLS and network options, creating it on first use.
+// Relative file paths are resolved from dir, the directory of the suite file.
+func getTransport(opts tlsOptions, network networkOptions, dir string) (*http.Transport, error) {
+	key, _ := json.Marshal([]any{opts, network})
+	if tr, ok := transports[string(key)]; ok {
+		return tr, nil
+	}
+
+	tlsConfig, err := buildTLSConfig(opts, dir)
+	if err != nil {
+		return nil, err
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+	if err := network.apply(tr, dir); err != nil {
+		return nil, err
+	}
+	transports[string(key)] = tr
+	return tr, nil
+}
+
+// applyFlags applies the -proxy, -unix-socket and -resolve command line flags over the suite options.
+func (n *networkOptions) applyFlags() {
+	if proxy != nil && *proxy != "" {
+		n.Proxy = *proxy
+	}
+	if unix_socket != nil && *unix_socket != "" {
+		// Relative to the working directory, unlike the suite option
+		n.UnixSocket, _ = filepath.Abs(*unix_socket)
+	}
+	// Later entries win, so the flags take precedence over the suite for the same host and port
+	n.Resolve = append(n.Resolve, resolve...)
+}
+
+// apply configures the proxy and the dialer of a transport.
+// A relative unix_socket is resolved from dir.
+func (n networkOptions) apply(tr *http.Transport, dir string) error {
+	if n.Proxy != "" && n.UnixSocket != "" {
+		return fmt.Errorf("proxy and unix_socket cannot be used together")
+	}
+	socket := n.UnixSocket
+	if socket != "" && !filepath.IsAbs(socket) {
+		socket = filepath.Join(dir, socket)
+	}
+	if n.Proxy != "" {
+		proxyUrl, err := url.Parse(n.Proxy)
+		if err != nil || proxyUrl.Host == "" {
+			return fmt.Errorf("invalid proxy '%s'", n.Proxy)
+		}
+		tr.Proxy = http.ProxyURL(proxyUrl)
+	}
+	if n.UnixSocket != "" {
+		// The socket is the destination, HTTP_PROXY must not redirect the requests elsewhere
+		tr.Proxy = nil
+	}
+
+	pinned, err := parseResolve(n.Resolve)
+	if err != nil {
+		return err
+	}
+	if len(pinned) == 0 && n.UnixSocket == "" {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if n.UnixSocket != "" {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err == nil {
+			if target, ok := pinned[strings.ToLower(host)+":"+port]; ok {
+				addr = net.JoinHostPort(target, port)
+			} else if target, ok := pinned[strings.ToLower(host)+":*"]; ok {
+				addr = net.JoinHostPort(target, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return nil
+}
+
+// parseResolve parses "host:port:address" entries into a map of "host:port" to address.
+func parseResolve(entries []string) (map[string]string, error) {
+	pinned := map[string]string{}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid resolve entry '%s', expected host:port:address", entry)
+		}
+		if _, err := strconv.Atoi(parts[1]); err != nil && parts[1] != "*" {
+			return nil, fmt.Errorf("invalid port in resolve entry '%s'", entry)
+		}
+		// IPv6 addresses may be written in brackets, as in curl
+		address := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		if net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("invalid address in resolve entry '%s'", entry)
+		}
+		pinned[strings.ToLower(parts[0])+":"+parts[1]] = address
+	}
+	return pinned, nil
+}
+
+// buildTLSConfig loads the certificates of the TLS options. It returns nil for the default configuration.
+func buildTLSConfig(opts tlsOptions, dir string) (*tls.Config, error) {
+	skipVerify := opts.InsecureSkipVerify != nil && *opts.InsecureSkipVerify
+	opts.InsecureSkipVerify = nil
+	if opts == (tlsOptions{}) && !skipVerify {
+		return nil, nil
+	}
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	config := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if opts.MinVersion != "" {
+		version, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_version '%s' (use 1.0, 1.1, 1.2 or 1.3)", opts.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if opts.CA != "" {
+		pem, err := os.ReadFile(resolve(opts.CA))
+		if err != nil {
+			return nil, fmt.Errorf("ca: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca: no PEM certificate found in %s", opts.CA)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.Cert != "" || opts.Key != "" {
+		if opts.Cert == "" || opts.Key == "" {
+			return nil, fmt.Errorf("cert and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(resolve(opts.Cert), resolve(opts.Key))
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block to a file of dir and returns its path.
+func writePEM(tb testing.TB, dir, name, blockType string, der []byte) string {
+	tb.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	return p
+}
+
+// serverCA writes the certificate of a test server as a CA bundle.
+func serverCA(tb testing.TB, srv *httptest.Server, dir string) string {
+	tb.Helper()
+	return writePEM(tb, dir, "server-ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+}
+
+// newClientCert creates a CA and a client certificate signed by it. It returns the CA pool
+// for the server and the paths of the client certificate and key.
+func newClientCert(tb testing.TB, dir string) (*x509.CertPool, string, string) {
+	tb.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "backwater-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, writePEM(tb, dir, "client.pem", "CERTIFICATE", clientDER), writePEM(tb, dir, "client.key", "PRIVATE KEY", keyDER)
+}
+
+// tlsGet sends a GET request with the transport of the TLS options.
+func tlsGet(tb testing.TB, opts tlsOptions, dir, url string) (*http.Response, error) {
+	tb.Helper()
+	tr, err := getTransport(opts, networkOptions{}, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.CloseIdleConnections()
+	res, err := (&http.Client{Transport: tr}).Get(url)
+	if err == nil {
+		res.Body.Close()
+	}
+	return res, err
+}
+
+func TestTLSCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	dir := t.TempDir()
+	serverCA(t, srv, dir)
+
+	if _, err := tlsGet(t, tlsOptions{}, dir, srv.URL); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("without ca: got %v, want a certificate error", err)
+	}
+	// A relative path is resolved from the suite directory
+	if res, err := tlsGet(t, tlsOptions{CA: "server-ca.pem"}, dir, srv.URL); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("with ca: got %v", err)
+	}
+	yes, no := true, false
+	if res, err := tlsGet(t, tlsOptions{InsecureSkipVerify: &yes}, dir, srv.URL); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("insecure_skip_verify: got %v", err)
+	}
+	// A test turning it off again verifies the certificate
+	if _, err := tlsGet(t, mergeTLS(&tlsOptions{InsecureSkipVerify: &yes}, &tlsOptions{InsecureSkipVerify: &no}), dir, srv.URL); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("insecure_skip_verify overridden to false: got %v, want a certificate error", err)
+	}
+
+	if _, err := buildTLSConfig(tlsOptions{CA: "missing.pem"}, dir); err == nil || !strings.HasPrefix(err.Error(), "ca: ") {
+		t.Errorf("missing ca file: got %v", err)
+	}
+	notPEM := filepath.Join(dir, "not.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+	if _, err := buildTLSConfig(tlsOptions{CA: notPEM}, dir); err == nil || !strings.Contains(err.Error(), "no PEM certificate found") {
+		t.Errorf("invalid ca file: got %v", err)
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	clientCAs, certFile, keyFile := newClientCert(t, dir)
+
+	var peer string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	ca := serverCA(t, srv, dir)
+
+	if _, err := tlsGet(t, tlsOptions{CA: ca}, dir, srv.URL); err == nil {
+		t.Error("without a client certificate: expected the handshake to fail")
+	}
+	res, err := tlsGet(t, tlsOptions{CA: ca, Cert: certFile, Key: keyFile}, dir, srv.URL)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("with a client certificate: got %v", err)
+	}
+	if peer != "backwater-client" {
+		t.Errorf("server saw client %q, want backwater-client", peer)
+	}
+
+	if _, err := buildTLSConfig(tlsOptions{Cert: certFile}, dir); err == nil || err.Error() != "cert and key must be set together" {
+		t.Errorf("cert without key: got %v", err)
+	}
+	if _, err := buildTLSConfig(tlsOptions{Cert: certFile, Key: ca}, dir); err == nil || !strings.HasPrefix(err.Error(), "client certificate: ") {
+		t.Errorf("invalid key: got %v", err)
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	var sni string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sni = r.TLS.ServerName
+	}))
+	srv.StartTLS()
+	defer srv.Close()
+	dir := t.TempDir()
+	ca := serverCA(t, srv, dir)
+
+	// The httptest certificate is valid for example.com, not for other names
+	res, err := tlsGet(t, tlsOptions{CA: ca, ServerName: "example.com"}, dir, srv.URL)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("server_name example.com: got %v", err)
+	}
+	if sni != "example.com" {
+		t.Errorf("server saw SNI %q, want example.com", sni)
+	}
+	if _, err := tlsGet(t, tlsOptions{CA: ca, ServerName: "api.internal"}, dir, srv.URL); err == nil || !strings.Contains(err.Error(), "api.internal") {
+		t.Errorf("server_name api.internal: got %v, want a name mismatch", err)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	dir := t.TempDir()
+	ca := serverCA(t, srv, dir)
+
+	tests := []struct {
+		version string
+		ok      bool
+	}{
+		{"", true},
+		{"1.2", true},
+		{"1.3", false},
+	}
+	for _, tt := range tests {
+		_, err := tlsGet(t, tlsOptions{CA: ca, MinVersion: tt.version}, dir, srv.URL)
+		if (err == nil) != tt.ok {
+			t.Errorf("min_version %q against a TLS 1.2 server: got %v, want success %v", tt.version, err, tt.ok)
+		}
+	}
+	if _, err := buildTLSConfig(tlsOptions{MinVersion: "1.4"}, dir); err == nil || !strings.Contains(err.Error(), "unknown min_version '1.4'") {
+		t.Errorf("min_version 1.4: got %v", err)
+	}
+}
+
+func TestMergeTLS(t *testing.T) {
+	suite := &tlsOptions{CA: "suite-ca.pem", Cert: "suite.pem", Key: "suite.key", MinVersion: "1.2"}
+	got := mergeTLS(suite, &tlsOptions{Cert: "test.pem", Key: "test.key", ServerName: "api.local"})
+	want := tlsOptions{CA: "suite-ca.pem", Cert: "test.pem", Key: "test.key", ServerName: "api.local", MinVersion: "1.2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// insecure_skip_verify of a test overrides the suite's either way
+	yes, no := true, false
+	tests := []struct {
+		suite, test *bool
+		want        bool
+	}{
+		{nil, nil, false},
+		{&yes, nil, true},
+		{nil, &yes, true},
+		{&yes, &no, false},
+		{&no, &yes, true},
+	}
+	for i, tt := range tests {
+		merged := mergeTLS(&tlsOptions{InsecureSkipVerify: tt.suite}, &tlsOptions{InsecureSkipVerify: tt.test})
+		config, err := buildTLSConfig(merged, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config != nil && config.InsecureSkipVerify; got != tt.want {
+			t.Errorf("case %d: got insecure_skip_verify %v, want %v", i, got, tt.want)
+		}
+	}
+
+	if got := mergeTLS(nil, nil); !reflect.DeepEqual(got, tlsOptions{}) {
+		t.Errorf("no options: got %+v", got)
+	}
+	if config, err := buildTLSConfig(tlsOptions{}, ""); config != nil || err != nil {
+		t.Errorf("default options: got %v, %v, want the default configuration", config, err)
+	}
+}
+
+// networkGet sends a GET to url through a transport with the network options, and returns the body.
+func networkGet(tb testing.TB, n networkOptions, dir, url string) (string, error) {
+	tb.Helper()
+	tr := &http.Transport{}
+	if err := n.apply(tr, dir); err != nil {
+		return "", err
+	}
+	defer tr.CloseIdleConnections()
+	res, err := (&http.Client{Transport: tr}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+// hostEcho answers with the name of the server and the Host of the request.
+func hostEcho(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", name, r.Host, r.URL)
+	})
+}
+
+func TestNetworkProxy(t *testing.T) {
+	// The proxy receives the absolute URL of the request
+	proxyServer := httptest.NewServer(hostEcho("proxy"))
+	defer proxyServer.Close()
+
+	got, err := networkGet(t, networkOptions{Proxy: proxyServer.URL}, "", "http://api.invalid:8080/users?id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "proxy api.invalid:8080 http://api.invalid:8080/users?id=1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, n := range []networkOptions{
+		{Proxy: "127.0.0.1"},
+		{Proxy: "http://[::1"},
+		{Proxy: proxyServer.URL, UnixSocket: "api.sock"},
+	} {
+		if err := n.apply(&http.Transport{}, ""); err == nil {
+			t.Errorf("%+v: expected an error", n)
+		}
+	}
+}
+
+func TestNetworkResolve(t *testing.T) {
+	server := httptest.NewServer(hostEcho("pinned"))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	tests := []struct {
+		resolve []string
+		url     string
+	}{
+		{[]string{"api.invalid:" + port + ":127.0.0.1"}, "http://api.invalid:" + port + "/a"},
+		{[]string{"API.invalid:*:127.0.0.1"}, "http://api.invalid:" + port + "/a"},
+		{[]string{"api.invalid:" + port + ":[::2]", "api.invalid:" + port + ":127.0.0.1"}, "http://api.invalid:" + port + "/a"},
+	}
+	for _, tt := range tests {
+		got, err := networkGet(t, networkOptions{Resolve: tt.resolve}, "", tt.url)
+		if err != nil {
+			t.Errorf("%q: %v", tt.resolve, err)
+			continue
+		}
+		// The Host header keeps the name of the URL
+		if want := "pinned api.invalid:" + port + " /a"; got != want {
+			t.Errorf("%q: got %q, want %q", tt.resolve, got, want)
+		}
+	}
+
+	// Hosts that are not pinned are dialed as usual
+	if _, err := networkGet(t, networkOptions{Resolve: []string{"127.0.0.2:1:127.0.0.1"}}, "", server.URL+"/a"); err != nil {
+		t.Errorf("unpinned address: %v", err)
+	}
+}
+
+func TestParseResolve(t *testing.T) {
+	got, err := parseResolve([]string{"API.local:443:10.0.0.1", "api.local:*:::1", "v6.local:80:[2001:db8::1]", "api.local:443:10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"api.local:443": "10.0.0.2", "api.local:*": "::1", "v6.local:80": "2001:db8::1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, entry := range []string{"api.local:443", ":443:10.0.0.1", "api.local:443:", "api.local:https:10.0.0.1", "api.local:443:not-an-ip"} {
+		if _, err := parseResolve([]string{entry}); err == nil {
+			t.Errorf("%q: expected an error", entry)
+		}
+	}
+}
+
+func TestNetworkUnixSocket(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, shorter than some temporary directories
+	dir, err := os.MkdirTemp("", "bw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("unix", filepath.Join(dir, "api.sock"))
+	if err != nil {
+		t.Skipf("unix sockets are not available: %v", err)
+	}
+	server := &http.Server{Handler: hostEcho("socket")}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// A relative socket is resolved from the suite directory, the URL host is only the Host header
+	for _, socket := range []string{"api.sock", filepath.Join(dir, "api.sock")} {
+		got, err := networkGet(t, networkOptions{UnixSocket: socket}, dir, "http://api.local/users")
+		if err != nil {
+			t.Errorf("%s: %v", socket, err)
+			continue
+		}
+		if want := "socket api.local /users"; got != want {
+			t.Errorf("%s: got %q, want %q", socket, got, want)
+		}
+	}
+
+	// A proxy from the environment does not take the requests away from the socket
+	tr := &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: "127.0.0.1:1"})}
+	if err := (networkOptions{UnixSocket: "api.sock"}).apply(tr, dir); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Proxy != nil {
+		t.Error("the proxy must be removed")
+	}
+}
+
+func TestNetworkFlags(t *testing.T) {
+	defer func(p, u *string, r stringList) { proxy, unix_socket, resolve = p, u, r }(proxy, unix_socket, resolve)
+
+	// Without flags the suite options are kept
+	proxy, unix_socket, resolve = nil, nil, nil
+	n := networkOptions{Proxy: "http://suite:8080", Resolve: []string{"api.local:443:10.0.0.1"}, UnixSocket: "suite.sock"}
+	n.applyFlags()
+	if want := (networkOptions{Proxy: "http://suite:8080", Resolve: []string{"api.local:443:10.0.0.1"}, UnixSocket: "suite.sock"}); !reflect.DeepEqual(n, want) {
+		t.Errorf("no flags: got %+v", n)
+	}
+
+	flags := flag.NewFlagSet("backwater", flag.ContinueOnError)
+	proxy = flags.String("proxy", "", "")
+	unix_socket = flags.String("unix-socket", "", "")
+	flags.Var(&resolve, "resolve", "")
+	if err := flags.Parse([]string{"-proxy", "http://cli:3128", "-unix-socket", "run/api.sock", "-resolve", "api.local:443:10.0.0.2", "-resolve", "web.local:*:10.0.0.3"}); err != nil {
+		t.Fatal(err)
+	}
+	n.applyFlags()
+	wd, _ := os.Getwd()
+	want := networkOptions{
+		Proxy:      "http://cli:3128",
+		Resolve:    []string{"api.local:443:10.0.0.1", "api.local:443:10.0.0.2", "web.local:*:10.0.0.3"},
+		UnixSocket: filepath.Join(wd, "run", "api.sock"),
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("got %+v, want %+v", n, want)
+	}
+	// The -resolve flag wins over the suite for the same host and port
+	if pinned, _ := parseResolve(n.Resolve); pinned["api.local:443"] != "10.0.0.2" {
+		t.Errorf("pinned: got %v", pinned)
+	}
+	if got := resolve.String(); got != "api.local:443:10.0.0.2,web.local:*:10.0.0.3" {
+		t.Errorf("flag value: got %s", got)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useTest makes tc the current test of LogMsg and recordMismatch. Call the returned function to restore the previous one.
+func useTest(tc *test) (restore func()) {
+	previous := t
+	t = tc
+	return func() { t = previous }
+}
+
+// validateCase is an expected_response checked against an actual body, both written as JSON.
+type validateCase struct {
+	name     string
+	expected string
+	actual   string
+	mode     matchMode
+	want     bool
+}
+
+// runValidateCases checks that the quiet and the reporting validations agree with want.
+func runValidateCases(t *testing.T, tests []validateCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, actual := mustDecode(t, tt.expected), mustDecode(t, tt.actual)
+			if got := validateBody(expected, actual, "", nil, tt.mode); got != tt.want {
+				t.Errorf("quiet: got %v, want %v", got, tt.want)
+			}
+			found := collectMismatches(expected, actual, "", tt.mode)
+			if (len(found) == 0) != tt.want {
+				t.Errorf("reporting: got mismatches %+v, want success %v", found, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBodyModes(t *testing.T) {
+	strict := matchMode{Strict: true}
+	ordered := matchMode{Ordered: true}
+	exact := matchMode{ExactLength: true}
+	runValidateCases(t, []validateCase{
+		// Default: subset objects, unordered arrays, extra items allowed
+		{"subset", `{"id": 1}`, `{"id": 1, "name": "a"}`, matchMode{}, true},
+		{"unordered", `[1, 2]`, `[3, 2, 1]`, matchMode{}, true},
+		{"duplicates need as many items", `[1, 1]`, `[1, 2]`, matchMode{}, false},
+		{"nested subset", `{"a": [{"b": 1}]}`, `{"a": [{"c": 2}, {"b": 1, "d": 3}]}`, matchMode{}, true},
+
+		// strict
+		{"strict extra key", `{"id": 1}`, `{"id": 1, "password_hash": "x"}`, strict, false},
+		{"strict same keys", `{"id": 1, "name": "a"}`, `{"name": "a", "id": 1}`, strict, true},
+		{"strict applies to nested objects", `{"user": {"id": 1}}`, `{"user": {"id": 1, "role": "admin"}}`, strict, false},
+		{"strict ignores array length", `[1]`, `[1, 2]`, strict, true},
+
+		// ordered
+		{"ordered", `[1, 2]`, `[1, 2, 3]`, ordered, true},
+		{"ordered wrong order", `[1, 2]`, `[2, 1]`, ordered, false},
+		{"ordered objects", `[{"id": 1}, {"id": 2}]`, `[{"id": 1, "x": 0}, {"id": 2}]`, ordered, true},
+
+		// exact_length
+		{"exact_length extra item", `[1, 2]`, `[2, 1, 3]`, exact, false},
+		{"exact_length unordered", `[1, 2]`, `[2, 1]`, exact, true},
+		{"exact_length empty", `[]`, `[1]`, exact, false},
+		{"all modes", `[{"id": 1}, {"id": 2}]`, `[{"id": 1}, {"id": 2}]`, matchMode{Strict: true, Ordered: true, ExactLength: true}, true},
+
+		// "$mode" key on objects
+		{"$mode strict", `{"$mode": "strict", "id": 1}`, `{"id": 1, "extra": true}`, matchMode{}, false},
+		{"$mode strict passes", `{"$mode": "strict", "id": 1}`, `{"id": 1}`, matchMode{}, true},
+		{"$mode is inherited", `{"$mode": "strict", "user": {"id": 1}}`, `{"user": {"id": 1, "extra": true}}`, matchMode{}, false},
+		{"$mode subset switches strict off", `{"id": 1, "meta": {"$mode": "subset", "v": 1}}`, `{"id": 1, "meta": {"v": 1, "w": 2}}`, strict, true},
+		{"$mode ordered for arrays below", `{"$mode": "ordered", "a": [1, 2]}`, `{"a": [2, 1]}`, matchMode{}, false},
+		{"$mode unknown", `{"$mode": "loose", "id": 1}`, `{"id": 1}`, matchMode{}, false},
+
+		// "$mode:" marker as the first array item
+		{"$mode:ordered", `["$mode:ordered", "a", "b"]`, `["b", "a"]`, matchMode{}, false},
+		{"$mode:ordered passes", `["$mode:ordered", "a", "b"]`, `["a", "b", "c"]`, matchMode{}, true},
+		{"$mode:ordered,exact_length", `["$mode:ordered,exact_length", "a", "b"]`, `["a", "b", "c"]`, matchMode{}, false},
+		{"$mode:unordered switches ordered off", `["$mode:unordered", 2, 1]`, `[1, 2]`, ordered, true},
+		{"$mode: only marker", `["$mode:exact_length"]`, `[]`, matchMode{}, true},
+		{"$mode: marker is not an item", `["$mode:exact_length", 1]`, `[1]`, matchMode{}, true},
+		{"$mode: unknown", `["$mode:sorted", 1]`, `[1]`, matchMode{}, false},
+		{"$mode: in items", `[["$mode:strict", {"id": 1}]]`, `[[{"id": 1, "x": 2}]]`, matchMode{}, false},
+
+		// Types
+		{"object expected", `{"id": 1}`, `[1]`, matchMode{}, false},
+		{"array expected", `[1]`, `{"id": 1}`, matchMode{}, false},
+		{"null expected matches anything", `{"id": null}`, `{"id": 5}`, matchMode{}, true},
+		{"string is not number", `{"id": "1"}`, `{"id": 1}`, matchMode{}, false},
+	})
+}
+
+func TestParseMatchMode(t *testing.T) {
+	tests := []struct {
+		spec string
+		base matchMode
+		want matchMode
+		ok   bool
+	}{
+		{"", matchMode{}, matchMode{}, true},
+		{"strict", matchMode{}, matchMode{Strict: true}, true},
+		{"strict, ordered ,exact_length", matchMode{}, matchMode{Strict: true, Ordered: true, ExactLength: true}, true},
+		{"subset,unordered", matchMode{Strict: true, Ordered: true, ExactLength: true}, matchMode{ExactLength: true}, true},
+		{"strict,nope", matchMode{Ordered: true}, matchMode{Ordered: true}, false},
+	}
+	for _, tt := range tests {
+		if got, ok := parseMatchMode(tt.spec, tt.base); got != tt.want || ok != tt.ok {
+			t.Errorf("%q on %+v: got %+v, %v, want %+v, %v", tt.spec, tt.base, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestValidateBodyNegativeAssertions(t *testing.T) {
+	strict := matchMode{Strict: true}
+	runValidateCases(t, []validateCase{
+		{"$absent", `{"password": "$absent"}`, `{"id": 1}`, matchMode{}, true},
+		{"$absent present", `{"password": "$absent"}`, `{"id": 1, "password": "x"}`, matchMode{}, false},
+		{"$absent present as null", `{"password": "$absent"}`, `{"password": null}`, matchMode{}, false},
+		{"$absent in strict mode", `{"id": 1, "password": "$absent"}`, `{"id": 1}`, strict, true},
+		{"$absent nested", `{"user": {"internal_id": "$absent"}}`, `{"user": {"id": 1}}`, matchMode{}, true},
+		{"$absent in array items", `[{"id": 1, "secret": "$absent"}]`, `[{"id": 1, "secret": 2}, {"id": 1}]`, matchMode{}, true},
+		{"$absent as an array item", `["$absent"]`, `[1]`, matchMode{}, false},
+
+		{"$null", `{"deleted_at": "$null"}`, `{"deleted_at": null}`, matchMode{}, true},
+		{"$null missing", `{"deleted_at": "$null"}`, `{}`, matchMode{}, false},
+		{"$null with a value", `{"deleted_at": "$null"}`, `{"deleted_at": "2024-01-01"}`, matchMode{}, false},
+		{"$null empty string", `{"deleted_at": "$null"}`, `{"deleted_at": ""}`, matchMode{}, false},
+
+		{"not: string", `{"role": "not:admin"}`, `{"role": "user"}`, matchMode{}, true},
+		{"not: equal string", `{"role": "not:admin"}`, `{"role": "admin"}`, matchMode{}, false},
+		{"not: number", `{"count": "not:0"}`, `{"count": 3}`, matchMode{}, true},
+		{"not: equal number", `{"count": "not:0"}`, `{"count": 0}`, matchMode{}, false},
+		{"not: decimal", `{"price": "not:1.5"}`, `{"price": 1.50}`, matchMode{}, false},
+		{"not: boolean", `{"ok": "not:false"}`, `{"ok": false}`, matchMode{}, false},
+		{"not: null", `{"owner": "not:null"}`, `{"owner": null}`, matchMode{}, false},
+		{"not: needs the key", `{"role": "not:admin"}`, `{}`, matchMode{}, false},
+		{"not: string vs number text", `{"id": "not:1"}`, `{"id": "1"}`, matchMode{}, false},
+
+		{"not-regex:", `{"token": "not-regex:^tmp_"}`, `{"token": "live_1"}`, matchMode{}, true},
+		{"not-regex: matching", `{"token": "not-regex:^tmp_"}`, `{"token": "tmp_1"}`, matchMode{}, false},
+		{"not-regex: on a number", `{"code": "not-regex:^5"}`, `{"code": 404}`, matchMode{}, true},
+		{"not-regex: invalid", `{"token": "not-regex:("}`, `{"token": "a"}`, matchMode{}, false},
+
+		{"not: in an array", `["not:b"]`, `["b", "c"]`, matchMode{}, true},
+		{"not: in an array without a match", `["not:b"]`, `["b"]`, matchMode{}, false},
+	})
+}
+
+func TestRecordMismatchCollectsEveryFailure(t *testing.T) {
+	current := &test{}
+	defer useTest(current)()
+
+	expected := mustDecode(t, `{"id": 1, "user": {"name": "Ada", "role": "admin"}, "tags": ["a", "b"]}`)
+	actual := mustDecode(t, `{"id": 2, "user": {"name": "Bob"}, "tags": ["b"]}`)
+	if validateBody(expected, actual, "", recordMismatch, matchMode{}) {
+		t.Fatal("expected a failure")
+	}
+	var paths []string
+	for _, m := range current.Mismatches {
+		paths = append(paths, m.Path)
+	}
+	want := []string{"id", "tags", "tags[0]", "user.name", "user.role"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got paths %q, want %q", paths, want)
+	}
+	if len(current.Logs) != len(want) || !strings.HasPrefix(current.Logs[0], "[Validation Error] id: value mismatch") {
+		t.Errorf("logs: got %q", current.Logs)
+	}
+}
+
+func TestClosestCandidate(t *testing.T) {
+	expected := mustDecode(t, `[{"id": 2, "name": "Bob", "role": "admin"}]`)
+	actual := mustDecode(t, `[{"id": 1, "name": "Ada", "role": "user"}, {"id": 2, "name": "Bob", "role": "user"}]`)
+	want := []mismatch{
+		{"[0]", `{"id":2,"name":"Bob","role":"admin"}`, `{"id":2,"name":"Bob","role":"user"}`,
+			"no matching item in actual array (order is ignored); closest is [1] with 1 problem(s)"},
+		{"[1].role", `"admin"`, `"user"`, "closest candidate: string mismatch"},
+	}
+	if got := collectMismatches(expected, actual, "", matchMode{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		want     []string
+	}{
+		// An item already matched by another expected item is not a candidate
+		{"used items are skipped", `{"items": [{"id": 1}, {"id": 1, "ok": true}]}`, `{"items": [{"id": 1, "ok": true}, {"id": 3}]}`,
+			[]string{"items[1]: no matching item in actual array (order is ignored); closest is items[1] with 2 problem(s)",
+				"items[1].id: closest candidate: value mismatch", "items[1].ok: closest candidate: missing expected key"}},
+		{"plain values have no candidate", `[1, 5]`, `[1, 2, 3]`,
+			[]string{"[1]: no matching item in actual array (order is ignored)"}},
+		{"no unused item left", `[{"id": 1}, {"id": 2}]`, `[{"id": 1}]`,
+			[]string{"(root): array is shorter than expected", "[1]: no matching item in actual array (order is ignored)"}},
+		{"nested arrays", `[["a", "c"]]`, `[["a", "b"], ["x"]]`,
+			[]string{"[0]: no matching item in actual array (order is ignored); closest is [0] with 1 problem(s)",
+				"[0][1]: closest candidate: no matching item in actual array (order is ignored)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range collectMismatches(mustDecode(t, tt.expected), mustDecode(t, tt.actual), "", matchMode{}) {
+				got = append(got, m.Path+": "+m.Reason)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
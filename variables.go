@@ -1,30 +1,100 @@
 package main
 
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
 // inputType represents the root structure of the configuration file.
 // It contains the suite name, global variables, and the list of tests to execute.
 type inputType struct {
 	Name      string          `json:"name"`
 	Variables variablesStruct `json:"variables"`
-	Tests     []test          `json:"tests"`
+	// MaxDuration is the default max_duration for tests that do not set their own
+	MaxDuration string `json:"max_duration,omitempty"`
+	// SnapshotIgnore lists paths ignored by every snapshot test (e.g., "created_at")
+	SnapshotIgnore []string `json:"snapshot_ignore,omitempty"`
+	// FloatTolerance is the maximum difference for non-integer numbers in expected_response to still match
+	FloatTolerance float64 `json:"float_tolerance,omitempty"`
+	// Auth authenticates every test that does not set its own auth
+	Auth *authOptions `json:"auth,omitempty"`
+	// Sign signs every test that does not set its own sign (HMAC or AWS SigV4)
+	Sign *signOptions `json:"sign,omitempty"`
+	// TLS holds the TLS options of every test (CA bundle, client certificate, ...)
+	TLS *tlsOptions `json:"tls,omitempty"`
+	// CookieJar stores cookies in a jar shared by all tests (tests can opt out or use named jars)
+	CookieJar bool `json:"cookie_jar,omitempty"`
+	// networkOptions route every request through a proxy, pinned addresses or a Unix socket
+	// (the proxy, resolve and unix_socket keys, which the -proxy, -resolve and -unix-socket flags override)
+	networkOptions
+	// ReportAll validates the body and schema of every test even when the status does not match
+	ReportAll bool   `json:"report_all,omitempty"`
+	Tests     []test `json:"tests"`
 }
 
 // test defines the configuration for a single integration test step.
 // It includes request details (Method, URL, Body), expected outcomes,
 // and instructions on data extraction (ToStore).
 type test struct {
-	Number           int               `json:"num"`
-	Method           string            `json:"method"`
-	Url              string            `json:"url"`
-	Header           map[string]string `json:"header,omitempty"`
-	Body             any               `json:"body,omitempty"`
-	ActualStatus     string            `json:"actual_status"`
-	ExpectedStatus   string            `json:"expected_status"`
-	ActualResponse   string            `json:"actual_response"`
-	ExpectedResponse any               `json:"expected_response,omitempty"`
-	ToStore          map[string]string `json:"var_to_store,omitempty"`
-	TimeTaken        string            `json:"time"`
-	Logs             []string          `json:"logs"`
-	Pass             bool              `json:"pass"`
+	Number           int                `json:"num"`
+	Method           string             `json:"method"`
+	Url              string             `json:"url"`
+	Query            map[string]any     `json:"query,omitempty"`
+	Header           map[string]string  `json:"header,omitempty"`
+	Body             any                `json:"body,omitempty"`
+	BodyType         string             `json:"body_type,omitempty"`
+	BodyRaw          string             `json:"body_raw,omitempty"`
+	BodyFile         string             `json:"body_file,omitempty"`
+	GraphQL          *graphqlOptions    `json:"graphql,omitempty"`
+	WebSocket        *websocketOptions  `json:"websocket,omitempty"`
+	Messages         []websocketMessage `json:"messages,omitempty"`
+	ActualStatus     string             `json:"actual_status"`
+	ActualStatusCode int                `json:"actual_status_code"`
+	ExpectedStatus   any                `json:"expected_status"`
+	ActualResponse   string             `json:"actual_response"`
+	ExpectedResponse any                `json:"expected_response,omitempty"`
+	MatchMode        string             `json:"match_mode,omitempty"`
+	ReportAll        bool               `json:"report_all,omitempty"`
+	Mismatches       []mismatch         `json:"mismatches,omitempty"`
+	BodyDiff         []string           `json:"body_diff,omitempty"`
+	ExpectedSchema   any                `json:"expected_schema,omitempty"`
+	SchemaErrors     []string           `json:"schema_errors,omitempty"`
+	ActualHeaders    http.Header        `json:"actual_headers,omitempty"`
+	ExpectedHeaders  map[string]any     `json:"expected_headers,omitempty"`
+	Auth             *authOptions       `json:"auth,omitempty"`
+	Sign             *signOptions       `json:"sign,omitempty"`
+	TLS              *tlsOptions        `json:"tls,omitempty"`
+	FollowRedirects  *bool              `json:"follow_redirects,omitempty"`
+	MaxRedirects     int                `json:"max_redirects,omitempty"`
+	Redirects        []redirectHop      `json:"redirects,omitempty"`
+	FinalUrl         string             `json:"final_url,omitempty"`
+	ExpectedUrl      string             `json:"expected_url,omitempty"`
+	CookieJar        any                `json:"cookie_jar,omitempty"`
+	ExpectedCookies  map[string]any     `json:"expected_cookies,omitempty"`
+	Cookies          map[string]string  `json:"cookies,omitempty"`
+	ExpectedXML      map[string]any     `json:"expected_xml,omitempty"`
+	ExpectedHTML     map[string]any     `json:"expected_html,omitempty"`
+	DecodeJWT        []string           `json:"decode_jwt,omitempty"`
+	ToStore          map[string]string  `json:"var_to_store,omitempty"`
+	Assert           []string           `json:"assert,omitempty"`
+	AssertResults    []assertResult     `json:"assert_results,omitempty"`
+	TimeTaken        string             `json:"time"`
+	MaxDuration      string             `json:"max_duration,omitempty"`
+	Timing           *requestTiming     `json:"timing,omitempty"`
+	Snapshot         bool               `json:"snapshot,omitempty"`
+	SnapshotName     string             `json:"snapshot_name,omitempty"`
+	SnapshotIgnore   []string           `json:"snapshot_ignore,omitempty"`
+	SnapshotHeaders  []string           `json:"snapshot_headers,omitempty"`
+	Logs             []string           `json:"logs"`
+	Pass             bool               `json:"pass"`
+
+	// mode is the parsed MatchMode used as the default for validateBody
+	mode matchMode
+	// maxDuration is the parsed MaxDuration, zero means no limit
+	maxDuration time.Duration
+	// jar is the name of the cookie jar used by the test, "" for none
+	jar string
 }
 
 // variablesStruct is a map used to store dynamic values during test execution.
@@ -45,3 +115,27 @@ var path *string
 
 // template refers to template.html file path from which reports are generated
 var template_file *string
+
+// update_snapshots makes snapshot tests (re)record their snapshot instead of comparing
+var update_snapshots *bool
+
+// proxy overrides the proxy of the suite
+var proxy *string
+
+// unix_socket overrides the unix_socket of the suite
+var unix_socket *string
+
+// resolve adds host:port:address entries to the resolve list of the suite (the flag can be repeated)
+var resolve stringList
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
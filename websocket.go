@@ -0,0 +1,448 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the handshake key to compute Sec-WebSocket-Accept (RFC 6455).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultWebSocketTimeout is how long an expect step waits when no timeout is set.
+const defaultWebSocketTimeout = 5 * time.Second
+
+// maxWebSocketFrame bounds the size of a received frame, so a broken server cannot exhaust memory.
+const maxWebSocketFrame = 16 << 20
+
+// maxWebSocketMessage bounds the size of a message reassembled from fragments, for the same reason.
+var maxWebSocketMessage = 16 << 20
+
+// WebSocket frame opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// websocketOptions turns a test into a WebSocket exchange: after the handshake, the steps are run in order.
+type websocketOptions struct {
+	Subprotocols []string `json:"subprotocols,omitempty"`
+	// Timeout is the default wait of the expect steps (e.g. "2s")
+	Timeout string          `json:"timeout,omitempty"`
+	Steps   []websocketStep `json:"steps"`
+
+	// timeout is the parsed Timeout
+	timeout time.Duration
+}
+
+// websocketStep is either a message to send or a message to wait for.
+// 1. send: a string is sent as-is, other values as JSON text.
+// 2. expect: waits for a message matching it (as expected_response), skipping the others,
+// then stores values of the message with var_to_store.
+type websocketStep struct {
+	Send    any               `json:"send,omitempty"`
+	Expect  any               `json:"expect,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+	ToStore map[string]string `json:"var_to_store,omitempty"`
+
+	// timeout is the parsed Timeout, or the default of the test
+	timeout time.Duration
+}
+
+// websocketMessage is a message sent or received, shown in the report.
+type websocketMessage struct {
+	Direction string `json:"direction"`
+	Data      string `json:"data"`
+	// Time is the time since the connection was opened
+	Time string `json:"time"`
+}
+
+// websocketConn is a client connection. A goroutine reads the frames, answers pings
+// and queues the messages for the expect steps.
+type websocketConn struct {
+	rw       io.ReadWriteCloser
+	writeMu  sync.Mutex
+	messages chan string
+	done     chan struct{}
+	// err is why the reading stopped, set before messages is closed
+	err error
+}
+
+// parseTimeouts checks the steps and parses their timeouts.
+func (w *websocketOptions) parseTimeouts() error {
+	w.timeout = defaultWebSocketTimeout
+	if w.Timeout != "" {
+		d, err := time.ParseDuration(w.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout '%s': %v", w.Timeout, err)
+		}
+		w.timeout = d
+	}
+	for i := range w.Steps {
+		step := &w.Steps[i]
+		if (step.Send == nil) == (step.Expect == nil) {
+			return fmt.Errorf("step %d: set either send or expect", i+1)
+		}
+		step.timeout = w.timeout
+		if step.Timeout != "" {
+			d, err := time.ParseDuration(step.Timeout)
+			if err != nil {
+				return fmt.Errorf("step %d: invalid timeout '%s': %v", i+1, step.Timeout, err)
+			}
+			step.timeout = d
+		}
+	}
+	return nil
+}
+
+// runWebSocket opens the connection with the transport of the test and runs its steps.
+// The handshake is checked against expected_status (101 by default) and expected_headers,
+// so a rejected connection (e.g. 401) can be tested too.
+func (t *test) runWebSocket(testNo int, transport *http.Transport) bool {
+	u, err := url.Parse(t.Url)
+	if err != nil {
+		LogMsg("[FAIL] %v: Invalid Url '%s': %v\n", testNo, t.Url, err)
+		return false
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		LogMsg("[FAIL] %v: Could not create request: %v\n", testNo, err)
+		return false
+	}
+	for k, v := range t.Header {
+		req.Header.Set(k, v)
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	if len(t.WebSocket.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(t.WebSocket.Subprotocols, ", "))
+	}
+	if jar := cookieJar(t.jar); jar != nil {
+		for _, c := range jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+	}
+	if err := t.Auth.apply(req, transport); err != nil {
+		LogMsg("[FAIL] %v: Authentication failed: %v\n", testNo, err)
+		return false
+	}
+	if err := t.Sign.apply(req); err != nil {
+		LogMsg("[FAIL] %v: Request signing failed: %v\n", testNo, err)
+		return false
+	}
+
+	// The transport hands over the connection as the body of a 101 response
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		LogMsg("[FAIL] %v: Network error: %v\n", testNo, err)
+		return false
+	}
+	defer res.Body.Close()
+	t.ActualStatus = res.Status
+	t.ActualStatusCode = res.StatusCode
+	t.ActualHeaders = res.Header
+	t.FinalUrl = req.URL.String()
+	if jar := cookieJar(t.jar); jar != nil {
+		jar.SetCookies(req.URL, res.Cookies())
+	}
+
+	// Handshake checks
+	success := true
+	expectedStatus := t.expectedStatus()
+	if !matchStatus(expectedStatus, res.StatusCode) {
+		success = false
+		LogMsg("[FAIL] %v: Status Mismatch.\n\tExpected: %v\n\tGot:      %s\n", testNo, expectedStatus, res.Status)
+	} else {
+		LogMsg("[PASS] HTTP Status Matched.\n")
+	}
+	if t.ExpectedHeaders != nil {
+		if validateHeaders(t.ExpectedHeaders, res.Header) {
+			LogMsg("[PASS] Response Headers Matched.\n")
+		} else {
+			success = false
+			LogMsg("[FAIL] %v: Response Header Mismatch.\n", testNo)
+		}
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxWebSocketFrame))
+		t.ActualResponse = string(body)
+		LogMsg("[NOTE] The connection was not upgraded, skipping the steps.\n")
+		return success
+	}
+	accept := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+	if res.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		LogMsg("[FAIL] %v: Invalid Sec-WebSocket-Accept in the handshake.\n", testNo)
+		return false
+	}
+	rw, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		LogMsg("[FAIL] %v: The transport did not return a writable connection.\n", testNo)
+		return false
+	}
+	if protocol := res.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		LogMsg("[NOTE] Subprotocol: %s\n", protocol)
+	}
+
+	conn := &websocketConn{rw: rw, messages: make(chan string, 64), done: make(chan struct{})}
+	go conn.readLoop()
+	defer conn.close()
+
+	start := time.Now()
+	record := func(direction, data string) {
+		t.Messages = append(t.Messages, websocketMessage{Direction: direction, Data: data, Time: time.Since(start).Round(time.Millisecond).String()})
+	}
+
+	for i := range t.WebSocket.Steps {
+		step := &t.WebSocket.Steps[i]
+		at := fmt.Sprintf("steps[%d]", i)
+		if step.Send != nil {
+			data, ok := websocketPayload(step.Send)
+			if !ok {
+				LogMsg("[FAIL] %v: Failed to process %s.send.\n", testNo, at)
+				return false
+			}
+			if err := conn.writeFrame(wsText, []byte(data)); err != nil {
+				LogMsg("[FAIL] %v: %s: cannot send: %v\n", testNo, at, err)
+				return false
+			}
+			record("sent", data)
+			continue
+		}
+		if !t.expectMessage(testNo, conn, step, at, record) {
+			// The next steps usually depend on this message
+			return false
+		}
+	}
+	return success
+}
+
+// expectMessage waits for a message matching the expect of the step and stores its values.
+// Messages that do not match are skipped; when none matches in time, the mismatches of the last one are reported.
+func (t *test) expectMessage(testNo int, conn *websocketConn, step *websocketStep, at string, record func(string, string)) bool {
+	switch e := step.Expect.(type) {
+	case string:
+		var ok bool
+		if step.Expect, ok = processString(e); !ok {
+			LogMsg("[FAIL] %v: Failed to process %s.expect.\n", testNo, at)
+			return false
+		}
+	default:
+		if ok := processBody(step.Expect); !ok {
+			LogMsg("[FAIL] %v: Failed to process %s.expect.\n", testNo, at)
+			return false
+		}
+	}
+
+	timer := time.NewTimer(step.timeout)
+	defer timer.Stop()
+	var last []mismatch
+	received := 0
+	for {
+		select {
+		case message, ok := <-conn.messages:
+			if !ok {
+				LogMsg("[FAIL] %v: %s: the connection closed while waiting for a message: %v\n", testNo, at, conn.err)
+				reportMismatch(at, step.Expect, missingValue, "connection closed")
+				return false
+			}
+			record("received", message)
+			received++
+
+			// A string expectation matches the raw text, other values the decoded JSON
+			var actual any = message
+			if _, isString := step.Expect.(string); !isString {
+				if decoded, err := decodeJSON([]byte(message)); err == nil {
+					actual = decoded
+				}
+			}
+			if last = collectMismatches(step.Expect, actual, at, t.mode); len(last) > 0 {
+				continue
+			}
+
+			LogMsg("[PASS] %s: Received the expected message.\n", at)
+			for name, p := range step.ToStore {
+				keyName := fmt.Sprintf("test_%d_%s", testNo, name)
+				value, ok := getNestedValue(p, actual)
+				if !ok {
+					LogMsg("Failed to extract '%s' (path: %s).\n All the tests referencing this variable might fail.\n", name, p)
+					continue
+				}
+				variables[keyName] = value
+				LogMsg("[NOTE] Stored %s = %v\n", keyName, value)
+			}
+			return true
+
+		case <-timer.C:
+			LogMsg("[FAIL] %v: %s: No matching message within %v (%d received).\n", testNo, at, step.timeout, received)
+			if len(last) == 0 {
+				reportMismatch(at, step.Expect, missingValue, "no message received")
+			}
+			for _, m := range last {
+				recordMismatch(m)
+			}
+			return false
+		}
+	}
+}
+
+// websocketPayload substitutes the variables of a send value and encodes it: strings as-is, other values as JSON.
+func websocketPayload(value any) (string, bool) {
+	if s, isString := value.(string); isString {
+		return processString(s)
+	}
+	if ok := processBody(value); !ok {
+		return "", false
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// readLoop reads the frames until the connection is closed. Fragmented messages are reassembled.
+func (c *websocketConn) readLoop() {
+	defer close(c.messages)
+	var message []byte
+	for {
+		opcode, fin, payload, err := c.readFrame()
+		if err != nil {
+			c.err = err
+			return
+		}
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+		case wsPong:
+		case wsClose:
+			c.err = fmt.Errorf("closed by the server")
+			if len(payload) >= 2 {
+				reason := fmt.Sprintf("code %d", binary.BigEndian.Uint16(payload))
+				if len(payload) > 2 {
+					reason += ": " + string(payload[2:])
+				}
+				c.err = fmt.Errorf("closed by the server (%s)", reason)
+			}
+			return
+		default:
+			// Text, binary and continuation frames
+			if len(message)+len(payload) > maxWebSocketMessage {
+				c.err = fmt.Errorf("message of more than %d bytes is too large", maxWebSocketMessage)
+				return
+			}
+			message = append(message, payload...)
+			if !fin {
+				continue
+			}
+			select {
+			case c.messages <- string(message):
+			case <-c.done:
+				return
+			}
+			message = nil
+		}
+	}
+}
+
+// readFrame reads one frame. Server frames are not masked, but a masked frame is unmasked anyway.
+func (c *websocketConn) readFrame() (byte, bool, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, false, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, false, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, false, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebSocketFrame {
+		return 0, false, nil, fmt.Errorf("frame of %d bytes is too large", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+			return 0, false, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, false, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, fin, payload, nil
+}
+
+// writeFrame writes one masked frame, as required from clients.
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// close sends a normal closure and stops queueing messages. The connection itself is closed
+// with the response body, which also ends readLoop.
+func (c *websocketConn) close() {
+	close(c.done)
+	c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, 1000))
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frameBuffer is a connection reading from a fixed input and recording what is written.
+type frameBuffer struct {
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+func (b *frameBuffer) Read(p []byte) (int, error)  { return b.in.Read(p) }
+func (b *frameBuffer) Write(p []byte) (int, error) { return b.out.Write(p) }
+func (b *frameBuffer) Close() error                { return nil }
+
+// serverFrame encodes an unmasked frame, as sent by servers.
+func serverFrame(opcode byte, fin bool, payload []byte) []byte {
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	return append(frame, payload...)
+}
+
+func newFrameConn(input ...[]byte) (*websocketConn, *frameBuffer) {
+	rw := &frameBuffer{in: bytes.NewReader(bytes.Join(input, nil))}
+	return &websocketConn{rw: rw, messages: make(chan string, 8), done: make(chan struct{})}, rw
+}
+
+func TestWebSocketReadFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		opcode  byte
+		fin     bool
+		payload string
+	}{
+		// The examples of RFC 6455 section 5.7
+		{"unmasked text", []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'}, wsText, true, "Hello"},
+		{"masked text", []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, wsText, true, "Hello"},
+		{"first fragment", []byte{0x01, 0x03, 'H', 'e', 'l'}, wsText, false, "Hel"},
+		{"last fragment", []byte{0x80, 0x02, 'l', 'o'}, 0x0, true, "lo"},
+		{"ping", []byte{0x89, 0x05, 'H', 'e', 'l', 'l', 'o'}, wsPing, true, "Hello"},
+		{"empty", []byte{0x81, 0x00}, wsText, true, ""},
+		{"125 bytes", serverFrame(wsText, true, bytes.Repeat([]byte("a"), 125)), wsText, true, strings.Repeat("a", 125)},
+		{"16-bit length", serverFrame(wsText, true, bytes.Repeat([]byte("b"), 256)), wsText, true, strings.Repeat("b", 256)},
+		{"64-bit length", serverFrame(wsText, true, bytes.Repeat([]byte("c"), 65536)), wsText, true, strings.Repeat("c", 65536)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, _ := newFrameConn(tt.frame)
+			opcode, fin, payload, err := conn.readFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opcode != tt.opcode || fin != tt.fin || string(payload) != tt.payload {
+				t.Errorf("got opcode %#x fin %v payload of %d bytes, want opcode %#x fin %v payload of %d bytes",
+					opcode, fin, len(payload), tt.opcode, tt.fin, len(tt.payload))
+			}
+		})
+	}
+}
+
+func TestWebSocketReadFrameErrors(t *testing.T) {
+	tooLarge := binary.BigEndian.AppendUint64([]byte{0x82, 127}, maxWebSocketFrame+1)
+	conn, _ := newFrameConn(tooLarge)
+	if _, _, _, err := conn.readFrame(); err == nil || err.Error() != fmt.Sprintf("frame of %d bytes is too large", maxWebSocketFrame+1) {
+		t.Errorf("too large: got %v", err)
+	}
+	for _, frame := range [][]byte{{}, {0x81}, {0x81, 126, 0x01}, {0x81, 0x85, 0x37}, {0x81, 0x05, 'H'}} {
+		conn, _ := newFrameConn(frame)
+		if _, _, _, err := conn.readFrame(); err == nil {
+			t.Errorf("truncated frame %x: expected an error", frame)
+		}
+	}
+}
+
+func TestWebSocketWriteFrame(t *testing.T) {
+	tests := []struct {
+		size   int
+		header []byte
+	}{
+		{0, []byte{0x81, 0x80}},
+		{125, []byte{0x81, 0x80 | 125}},
+		{126, []byte{0x81, 0x80 | 126, 0x00, 126}},
+		{65535, []byte{0x81, 0x80 | 126, 0xFF, 0xFF}},
+		{65536, []byte{0x81, 0x80 | 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		payload := bytes.Repeat([]byte("x"), tt.size)
+		conn, rw := newFrameConn()
+		if err := conn.writeFrame(wsText, payload); err != nil {
+			t.Fatal(err)
+		}
+		frame := rw.out.Bytes()
+		if !bytes.HasPrefix(frame, tt.header) {
+			t.Errorf("%d bytes: got header %x, want %x", tt.size, frame[:len(tt.header)], tt.header)
+			continue
+		}
+		// Client frames are masked: the payload is not sent as-is, but decodes back to it
+		if len(frame) != len(tt.header)+4+tt.size {
+			t.Errorf("%d bytes: got a frame of %d bytes", tt.size, len(frame))
+		}
+		reader, _ := newFrameConn(frame)
+		if opcode, fin, got, err := reader.readFrame(); err != nil || opcode != wsText || !fin || !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes: decoded opcode %#x fin %v, %d bytes, %v", tt.size, opcode, fin, len(got), err)
+		}
+	}
+}
+
+func TestWebSocketReadLoop(t *testing.T) {
+	closePayload := binary.BigEndian.AppendUint16(nil, 1001)
+	conn, rw := newFrameConn(
+		serverFrame(wsText, true, []byte("first")),
+		// A ping between the fragments of a message is answered right away
+		serverFrame(wsText, false, []byte(`{"type":`)),
+		serverFrame(wsPing, true, []byte("heartbeat")),
+		serverFrame(0x0, false, []byte(`"echo",`)),
+		serverFrame(0x0, true, []byte(`"id":7}`)),
+		serverFrame(wsPong, true, nil),
+		serverFrame(wsClose, true, append(closePayload, "going away"...)),
+		serverFrame(wsText, true, []byte("after close")),
+	)
+	conn.readLoop()
+
+	var got []string
+	for m := range conn.messages {
+		got = append(got, m)
+	}
+	if want := []string{"first", `{"type":"echo","id":7}`}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("messages: got %q, want %q", got, want)
+	}
+	if conn.err == nil || conn.err.Error() != "closed by the server (code 1001: going away)" {
+		t.Errorf("err: got %v", conn.err)
+	}
+
+	pong, _ := newFrameConn(rw.out.Bytes())
+	if opcode, _, payload, err := pong.readFrame(); err != nil || opcode != wsPong || string(payload) != "heartbeat" {
+		t.Errorf("pong: got opcode %#x payload %q, %v", opcode, payload, err)
+	}
+	if _, _, _, err := pong.readFrame(); err == nil {
+		t.Error("only one pong should be written")
+	}
+
+	tests := []struct {
+		frame []byte
+		err   string
+	}{
+		{serverFrame(wsClose, true, nil), "closed by the server"},
+		{serverFrame(wsClose, true, binary.BigEndian.AppendUint16(nil, 1000)), "closed by the server (code 1000)"},
+		{nil, "EOF"},
+	}
+	for _, tt := range tests {
+		conn, _ := newFrameConn(tt.frame)
+		conn.readLoop()
+		if conn.err == nil || conn.err.Error() != tt.err {
+			t.Errorf("%x: got %v, want %q", tt.frame, conn.err, tt.err)
+		}
+	}
+}
+
+func TestWebSocketReadLoopMessageLimit(t *testing.T) {
+	defer func(previous int) { maxWebSocketMessage = previous }(maxWebSocketMessage)
+	maxWebSocketMessage = 10
+
+	// Fragments are counted together, a message of exactly the limit is fine
+	conn, _ := newFrameConn(
+		serverFrame(wsText, false, []byte("12345")),
+		serverFrame(0x0, true, []byte("67890")),
+		serverFrame(wsText, false, []byte("123456")),
+		serverFrame(0x0, false, []byte("7890")),
+		serverFrame(0x0, true, []byte("x")),
+		serverFrame(wsText, true, []byte("never read")),
+	)
+	conn.readLoop()
+	var got []string
+	for m := range conn.messages {
+		got = append(got, m)
+	}
+	if len(got) != 1 || got[0] != "1234567890" {
+		t.Errorf("messages: got %q", got)
+	}
+	if conn.err == nil || conn.err.Error() != "message of more than 10 bytes is too large" {
+		t.Errorf("err: got %v", conn.err)
+	}
+
+	// A test waiting for the message fails with the reason
+	tc := &test{}
+	defer useTest(tc)()
+	conn, _ = newFrameConn(serverFrame(wsText, false, []byte("123456")), serverFrame(0x0, true, []byte("7890x")))
+	go conn.readLoop()
+	step := &websocketStep{Expect: "1234567890x", timeout: time.Second}
+	if tc.expectMessage(1, conn, step, "steps[0]", func(string, string) {}) {
+		t.Fatal("the expect step must fail")
+	}
+	if logs := strings.Join(tc.Logs, ""); !strings.Contains(logs, "too large") {
+		t.Errorf("logs: %q", tc.Logs)
+	}
+	if len(tc.Mismatches) != 1 || tc.Mismatches[0].Path != "steps[0]" {
+		t.Errorf("mismatches: %+v", tc.Mismatches)
+	}
+}
+
+func TestWebSocketParseTimeouts(t *testing.T) {
+	tests := []struct {
+		options websocketOptions
+		err     string
+	}{
+		{websocketOptions{Steps: []websocketStep{{Send: "a", Expect: "b"}}}, "step 1: set either send or expect"},
+		{websocketOptions{Steps: []websocketStep{{Send: "a"}, {}}}, "step 2: set either send or expect"},
+		{websocketOptions{Timeout: "soon"}, "invalid timeout 'soon'"},
+		{websocketOptions{Steps: []websocketStep{{Expect: "a", Timeout: "5"}}}, "step 1: invalid timeout '5'"},
+	}
+	for _, tt := range tests {
+		if err := tt.options.parseTimeouts(); err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%+v: got %v, want %q", tt.options, err, tt.err)
+		}
+	}
+
+	options := websocketOptions{Timeout: "3s", Steps: []websocketStep{{Send: "a"}, {Expect: "b", Timeout: "250ms"}}}
+	if err := options.parseTimeouts(); err != nil {
+		t.Fatal(err)
+	}
+	if options.Steps[0].timeout != 3*time.Second || options.Steps[1].timeout != 250*time.Millisecond {
+		t.Errorf("got timeouts %v and %v", options.Steps[0].timeout, options.Steps[1].timeout)
+	}
+	options = websocketOptions{Steps: []websocketStep{{Expect: "b"}}}
+	if err := options.parseTimeouts(); err != nil || options.Steps[0].timeout != defaultWebSocketTimeout {
+		t.Errorf("default timeout: got %v, %v", options.Steps[0].timeout, err)
+	}
+}
+
+func TestWebSocketPayload(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{"ping", "ping"},
+		{`{"raw": true}`, `{"raw": true}`},
+		{mustDecode(t, `{"op": "subscribe", "channels": ["a", "b"], "n": 1.50}`), `{"channels":["a","b"],"n":1.50,"op":"subscribe"}`},
+		{mustDecode(t, `[1, null, false]`), `[1,null,false]`},
+	}
+	for _, tt := range tests {
+		if got, ok := websocketPayload(tt.value); !ok || got != tt.want {
+			t.Errorf("%v: got %s, %v, want %s", tt.value, got, ok, tt.want)
+		}
+	}
+}
+
+// websocketEchoServer upgrades the connection and answers each text message with an unrelated
+// message and then a fragmented echo. It starts with a ping and reports the pong payload.
+func websocketEchoServer(tb testing.TB, pongs chan<- string) *httptest.Server {
+	tb.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		netConn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			tb.Error(err)
+			return
+		}
+		defer netConn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\nSec-WebSocket-Protocol: chat\r\n\r\n",
+			base64.StdEncoding.EncodeToString(accept[:]))
+		buf.Write(serverFrame(wsPing, true, []byte("hb")))
+		buf.Flush()
+
+		conn := &websocketConn{rw: hijackedConn{buf.Reader, netConn}}
+		for {
+			opcode, _, payload, err := conn.readFrame()
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case wsPong:
+				pongs <- string(payload)
+			case wsClose:
+				netConn.Write(serverFrame(wsClose, true, payload))
+				return
+			case wsText:
+				reply := string(payload) + "!"
+				netConn.Write(serverFrame(wsText, true, []byte(`{"type":"noise"}`)))
+				netConn.Write(serverFrame(wsText, false, []byte(`{"type":"echo",`)))
+				netConn.Write(serverFrame(0x0, true, []byte(fmt.Sprintf(`"reply":%q,"id":7}`, reply))))
+			}
+		}
+	}))
+}
+
+// hijackedConn reads a hijacked connection through the buffered reader of the server.
+type hijackedConn struct {
+	r *bufio.Reader
+	net.Conn
+}
+
+func (c hijackedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func TestRunWebSocket(t *testing.T) {
+	pongs := make(chan string, 1)
+	server := websocketEchoServer(t, pongs)
+	defer server.Close()
+	defer delete(variables, "test_1_id")
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+
+	tc := &test{
+		Url:    "ws" + strings.TrimPrefix(server.URL, "http") + "/chat",
+		Header: map[string]string{"Authorization": "Bearer secret"},
+		WebSocket: &websocketOptions{
+			Subprotocols: []string{"chat"},
+			Timeout:      "2s",
+			Steps: []websocketStep{
+				{Send: "hello"},
+				{Expect: mustDecode(t, `{"type": "echo", "reply": "hello!"}`), ToStore: map[string]string{"id": "id"}},
+				{Send: "$test_1_id$"},
+				{Expect: mustDecode(t, `{"type": "echo", "reply": "7!"}`)},
+			},
+		},
+	}
+	if err := tc.WebSocket.parseTimeouts(); err != nil {
+		t.Fatal(err)
+	}
+	if !tc.runWebSocket(1, transport) {
+		t.Fatalf("run failed: %+v", tc.Messages)
+	}
+	if tc.ActualStatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status: got %d", tc.ActualStatusCode)
+	}
+	if got := fmt.Sprint(variables["test_1_id"]); got != "7" {
+		t.Errorf("stored id: got %s", got)
+	}
+	var log []string
+	for _, m := range tc.Messages {
+		log = append(log, m.Direction+" "+m.Data)
+	}
+	want := []string{
+		"sent hello",
+		`received {"type":"noise"}`,
+		`received {"type":"echo","reply":"hello!","id":7}`,
+		"sent 7",
+		`received {"type":"noise"}`,
+		`received {"type":"echo","reply":"7!","id":7}`,
+	}
+	if strings.Join(log, "\n") != strings.Join(want, "\n") {
+		t.Errorf("messages:\n%s\nwant\n%s", strings.Join(log, "\n"), strings.Join(want, "\n"))
+	}
+	select {
+	case got := <-pongs:
+		if got != "hb" {
+			t.Errorf("pong: got %q, want hb", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the ping was not answered")
+	}
+
+	// A rejected handshake is checked against expected_status and skips the steps
+	rejected := &test{Url: tc.Url, ExpectedStatus: mustDecode(t, "401"), WebSocket: &websocketOptions{Steps: tc.WebSocket.Steps}}
+	if !rejected.runWebSocket(2, transport) || rejected.ActualStatusCode != http.StatusUnauthorized || len(rejected.Messages) != 0 {
+		t.Errorf("rejected handshake: got status %d and %d messages", rejected.ActualStatusCode, len(rejected.Messages))
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The XPath subset used by expected_xml and "xpath:" variables:
+//
+// Paths:      /Envelope/Body/User, //User, //User/*, (//User)[1]/Name, ., .., relative paths in predicates
+// Selectors:  name (namespace prefixes are ignored), *, @attr, @*, text()
+// Predicates: [2], [last()], [position() < 3], [@id], [@id='7'], [name='foo'], [text()='foo'],
+//             [contains(@class, 'x')], [starts-with(name, 'a')], [not(...)], combined with and/or
+// Functions:  count(path) returns the number of matches
+//
+// Element names are matched by their local name, case-insensitively.
+
+// xpathCond is a compiled predicate, called with the 1-based position of the node among its candidates.
+type xpathCond func(n *markupNode, pos, size int) bool
+
+// xpathStep is one step of a location path.
+type xpathStep struct {
+	deep  bool   // preceded by "//": search all descendants instead of the children
+	kind  string // "element", "self", "parent", "attr" or "text"
+	name  string // element or attribute name, "*" for any
+	preds []xpathCond
+}
+
+// xpathPath is a parsed location path. A grouped path such as "(//User)[1]/Name" starts
+// from the matches of group, filtered by groupPreds over the whole set.
+type xpathPath struct {
+	absolute   bool
+	group      *xpathPath
+	groupPreds []xpathCond
+	steps      []xpathStep
+}
+
+type xpathParser struct {
+	src string
+	pos int
+}
+
+// evalXPath evaluates an XPath expression on a document. It returns the matched
+// elements (*markupNode), attribute and text() values (string), or a single json.Number for count().
+func evalXPath(doc *markupNode, expr string) ([]any, error) {
+	p := &xpathParser{src: strings.TrimSpace(expr)}
+	isCount := p.consume("count(")
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if isCount && !p.consume(")") {
+		return nil, p.errorf("expected ')'")
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return nil, p.errorf("unexpected '%s'", p.src[p.pos:])
+	}
+	results := path.eval(doc, doc)
+	if isCount {
+		return []any{json.Number(strconv.Itoa(len(results)))}, nil
+	}
+	return results, nil
+}
+
+// --- Parser ---
+
+func (p *xpathParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("xpath '%s' at %d: %s", p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *xpathParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// consume skips s if it comes next.
+func (p *xpathParser) consume(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// parseName reads an element or attribute name. A namespace prefix is dropped.
+func (p *xpathParser) parseName() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || c == '-' || c == ':' || c == '*' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '.' && p.pos > start {
+			p.pos++
+			continue
+		}
+		break
+	}
+	name := p.src[start:p.pos]
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// parsePath parses a location path, absolute ("/a", "//a") or relative ("a/b", "@id").
+func (p *xpathParser) parsePath() (*xpathPath, error) {
+	path := &xpathPath{}
+	p.skipSpace()
+	deep := false
+	if p.consume("(") {
+		group, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		path.group = group
+		if path.groupPreds, err = p.parsePredicates(); err != nil {
+			return nil, err
+		}
+		if p.consume("//") {
+			deep = true
+		} else if !p.consume("/") {
+			return path, nil
+		}
+	} else if p.consume("//") {
+		path.absolute, deep = true, true
+	} else if p.consume("/") {
+		path.absolute = true
+	}
+	for {
+		step, err := p.parseStep(deep)
+		if err != nil {
+			return nil, err
+		}
+		path.steps = append(path.steps, step)
+		if p.consume("//") {
+			deep = true
+		} else if p.consume("/") {
+			deep = false
+		} else {
+			return path, nil
+		}
+	}
+}
+
+func (p *xpathParser) parseStep(deep bool) (xpathStep, error) {
+	step := xpathStep{deep: deep}
+	switch {
+	case p.consume(".."):
+		step.kind = "parent"
+	case p.consume("."):
+		step.kind = "self"
+	case p.consume("@"):
+		step.kind, step.name = "attr", p.parseName()
+	case p.consume("text()"):
+		step.kind = "text"
+	default:
+		step.kind, step.name = "element", p.parseName()
+	}
+	if (step.kind == "element" || step.kind == "attr") && step.name == "" {
+		return step, p.errorf("expected a name")
+	}
+	var err error
+	step.preds, err = p.parsePredicates()
+	return step, err
+}
+
+// parsePredicates parses the [...] conditions following a step or a group.
+func (p *xpathParser) parsePredicates() ([]xpathCond, error) {
+	var preds []xpathCond
+	for p.consume("[") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume("]") {
+			return nil, p.errorf("expected ']'")
+		}
+		preds = append(preds, cond)
+	}
+	return preds, nil
+}
+
+func (p *xpathParser) parseOr() (xpathCond, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("or ") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(n *markupNode, pos, size int) bool { return l(n, pos, size) || right(n, pos, size) }
+	}
+	return left, nil
+}
+
+func (p *xpathParser) parseAnd() (xpathCond, error) {
+	left, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("and ") {
+		right, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(n *markupNode, pos, size int) bool { return l(n, pos, size) && right(n, pos, size) }
+	}
+	return left, nil
+}
+
+// parseCond parses a single predicate condition.
+func (p *xpathParser) parseCond() (xpathCond, error) {
+	p.skipSpace()
+	switch {
+	case p.consume("("):
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return cond, nil
+
+	case p.consume("not("):
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return func(n *markupNode, pos, size int) bool { return !cond(n, pos, size) }, nil
+
+	case p.consume("last()"):
+		return func(n *markupNode, pos, size int) bool { return pos == size }, nil
+
+	case p.consume("position()"):
+		op, want, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		return func(n *markupNode, pos, size int) bool { return compareXPath(strconv.Itoa(pos), op, want) }, nil
+
+	case strings.HasPrefix(p.src[p.pos:], "contains(") || strings.HasPrefix(p.src[p.pos:], "starts-with("):
+		fn, _, _ := strings.Cut(p.src[p.pos:], "(")
+		p.pos += len(fn) + 1
+		operand, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected ','")
+		}
+		arg, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return func(n *markupNode, pos, size int) bool {
+			for _, v := range operand.eval(n, rootOf(n)) {
+				s := markupValue(v)
+				if fn == "contains" && strings.Contains(s, arg) || fn == "starts-with" && strings.HasPrefix(s, arg) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a condition")
+	}
+
+	// Position: [2]
+	if c := p.src[p.pos]; c >= '0' && c <= '9' {
+		lit, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		index, err := strconv.Atoi(lit)
+		if err != nil {
+			return nil, p.errorf("invalid position '%s'", lit)
+		}
+		return func(n *markupNode, pos, size int) bool { return pos == index }, nil
+	}
+
+	// Existence ([@id], [name]) or comparison ([@id='7'], [price > 10])
+	operand, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) && strings.ContainsRune("=!<>", rune(p.src[p.pos])) {
+		op, want, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		return func(n *markupNode, pos, size int) bool {
+			for _, v := range operand.eval(n, rootOf(n)) {
+				if compareXPath(markupValue(v), op, want) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return func(n *markupNode, pos, size int) bool { return len(operand.eval(n, rootOf(n))) > 0 }, nil
+}
+
+// parseComparison parses an operator and a literal, e.g. "= 'foo'" or "< 3".
+func (p *xpathParser) parseComparison() (string, string, error) {
+	p.skipSpace()
+	var op string
+	for _, candidate := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		if p.consume(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return "", "", p.errorf("expected a comparison operator")
+	}
+	lit, err := p.parseLiteral()
+	return op, lit, err
+}
+
+// parseLiteral parses a quoted string or a number.
+func (p *xpathParser) parseLiteral() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", p.errorf("expected a value")
+	}
+	if q := p.src[p.pos]; q == '\'' || q == '"' {
+		end := strings.IndexByte(p.src[p.pos+1:], q)
+		if end == -1 {
+			return "", p.errorf("unterminated string")
+		}
+		lit := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return lit, nil
+	}
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.' || p.src[p.pos] == '-') {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("expected a value")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// compareXPath compares a value with a literal, as numbers when both are numeric.
+func compareXPath(value, op, want string) bool {
+	a, errA := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	b, errB := strconv.ParseFloat(want, 64)
+	numeric := errA == nil && errB == nil
+	switch op {
+	case "=":
+		return numeric && a == b || value == want
+	case "!=":
+		return !(numeric && a == b || value == want)
+	case "<":
+		return numeric && a < b
+	case "<=":
+		return numeric && a <= b
+	case ">":
+		return numeric && a > b
+	case ">=":
+		return numeric && a >= b
+	}
+	return false
+}
+
+// --- Evaluation ---
+
+// rootOf returns the document node of n.
+func rootOf(n *markupNode) *markupNode {
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// eval evaluates the path from the context node ctx.
+func (path *xpathPath) eval(ctx, root *markupNode) []any {
+	current := []any{ctx}
+	if path.absolute {
+		current = []any{root}
+	}
+	if path.group != nil {
+		current = filterXPath(path.group.eval(ctx, root), path.groupPreds)
+	}
+	for _, step := range path.steps {
+		var next []any
+		seen := map[any]bool{}
+		for _, item := range current {
+			node, ok := item.(*markupNode)
+			if !ok {
+				// Attribute and text values have no children
+				continue
+			}
+			for _, c := range filterXPath(step.candidates(node), step.preds) {
+				if cn, ok := c.(*markupNode); ok {
+					if seen[cn] {
+						continue
+					}
+					seen[cn] = true
+				}
+				next = append(next, c)
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// filterXPath applies predicates in turn, each one numbering the items left by the previous one.
+func filterXPath(items []any, preds []xpathCond) []any {
+	for _, cond := range preds {
+		var kept []any
+		for i, item := range items {
+			if n, ok := item.(*markupNode); ok && cond(n, i+1, len(items)) {
+				kept = append(kept, item)
+			}
+		}
+		items = kept
+	}
+	return items
+}
+
+// candidates returns what a step selects from a node, before the predicates.
+func (step xpathStep) candidates(node *markupNode) []any {
+	scope := []*markupNode{node}
+	if step.deep {
+		scope = append(scope, node.descendants()...)
+	}
+
+	var out []any
+	switch step.kind {
+	case "self":
+		for _, n := range scope {
+			out = append(out, n)
+		}
+	case "parent":
+		if node.parent != nil {
+			out = append(out, node.parent)
+		}
+	case "element":
+		elements := node.elements()
+		if step.deep {
+			elements = node.descendants()
+		}
+		for _, el := range elements {
+			if step.name == "*" || strings.EqualFold(el.name, step.name) {
+				out = append(out, el)
+			}
+		}
+	case "attr":
+		for _, n := range scope {
+			if step.name == "*" {
+				for _, k := range slices.Sorted(maps.Keys(n.attrs)) {
+					out = append(out, n.attrs[k])
+				}
+			} else if v, ok := n.attrs[step.name]; ok {
+				out = append(out, v)
+			}
+		}
+	case "text":
+		for _, n := range scope {
+			for _, c := range n.children {
+				if c.isText {
+					out = append(out, strings.TrimSpace(c.text))
+				}
+			}
+		}
+	}
+	return out
+}